@@ -0,0 +1,313 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AccessLogOption configures format and destination of access log
+type AccessLogOption struct {
+	Format   string
+	Template string
+	Output   string
+	MaxSize  int64
+	Interval time.Duration
+	Sample   float64
+	Exclude  []string
+}
+
+// AccessRecord is passed to access log template
+type AccessRecord struct {
+	Time      time.Time
+	Remote    string
+	Proxy     string
+	Scheme    string
+	User      string
+	Method    string
+	URI       string
+	Path      string
+	Proto     string
+	Host      string
+	Status    int
+	Source    string
+	Length    int64
+	Referer   string
+	UserAgent string
+	Elapsed   time.Duration
+	Header    http.Header
+}
+
+const (
+	CommonLogFormat   = `{{.Remote}} - {{or .User "-"}} [{{.Time.Format "02/Jan/2006:15:04:05 -0700"}}] "{{.Method}} {{.URI}} {{.Proto}}" {{.Status}} {{if ge .Length 0}}{{.Length}}{{else}}-{{end}}`
+	CombinedLogFormat = CommonLogFormat + ` "{{or .Referer "-"}}" "{{or .UserAgent "-"}}"`
+)
+
+// logFile is reopenable and rotatable log file
+type logFile struct {
+	lock     sync.Mutex
+	name     string
+	fp       *os.File
+	size     int64
+	opened   time.Time
+	maxsize  int64
+	interval time.Duration
+}
+
+func openLogFile(name string, maxsize int64, interval time.Duration) (*logFile, error) {
+	res := &logFile{name: name, maxsize: maxsize, interval: interval}
+	if err := res.open(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (f *logFile) open() error {
+	fp, err := os.OpenFile(f.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	f.fp = fp
+	f.size = st.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// Reopen closes and opens log file. used by logrotate
+func (f *logFile) Reopen() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.fp != nil {
+		if err := f.fp.Close(); err != nil {
+			slog.Error("close access log", "name", f.name, "error", err)
+		}
+	}
+	return f.open()
+}
+
+func (f *logFile) rotate() error {
+	if err := f.fp.Close(); err != nil {
+		slog.Error("close access log", "name", f.name, "error", err)
+	}
+	rotated := f.name + "." + time.Now().Format("20060102150405")
+	if err := os.Rename(f.name, rotated); err != nil {
+		slog.Error("rotate access log", "name", f.name, "error", err)
+	} else {
+		slog.Info("access log rotated", "name", f.name, "rotated", rotated)
+	}
+	return f.open()
+}
+
+func (f *logFile) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if (f.maxsize > 0 && f.size+int64(len(b)) > f.maxsize && f.size != 0) || (f.interval > 0 && time.Since(f.opened) >= f.interval) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.fp.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *logFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.fp.Close()
+}
+
+// AccessLogger writes access log by template, or by slog if format is slog or json
+type AccessLogger struct {
+	opt  AccessLogOption
+	out  io.Writer
+	tmpl *template.Template
+	lock sync.Mutex
+}
+
+func open_output(opt AccessLogOption) (io.Writer, error) {
+	switch {
+	case opt.Output == "" || opt.Output == "stderr":
+		return os.Stderr, nil
+	case opt.Output == "-" || opt.Output == "stdout":
+		return os.Stdout, nil
+	case strings.HasPrefix(opt.Output, "syslog"):
+		return open_syslog(opt.Output)
+	}
+	return openLogFile(opt.Output, opt.MaxSize, opt.Interval)
+}
+
+func NewAccessLogger(opt AccessLogOption) (*AccessLogger, error) {
+	res := AccessLogger{opt: opt}
+	var text string
+	switch opt.Format {
+	case "common":
+		text = CommonLogFormat
+	case "combined":
+		text = CombinedLogFormat
+	case "template":
+		text = opt.Template
+	}
+	if text != "" {
+		tmpl, err := template.New("accesslog").Parse(text + "\n")
+		if err != nil {
+			return nil, err
+		}
+		res.tmpl = tmpl
+	}
+	out, err := open_output(opt)
+	if err != nil {
+		return nil, err
+	}
+	res.out = out
+	return &res, nil
+}
+
+// logger returns slog logger for slog/json format
+func (a *AccessLogger) logger() *slog.Logger {
+	if a.opt.Format == "json" {
+		return slog.New(slog.NewJSONHandler(a.out, nil)).With("type", "accesslog")
+	}
+	return slog.New(slog.NewTextHandler(a.out, nil)).With("type", "accesslog")
+}
+
+// skip returns true if the request should not be logged
+func (a *AccessLogger) skip(r *http.Request) bool {
+	for _, pat := range a.opt.Exclude {
+		if matched, _ := path.Match(pat, r.URL.Path); matched {
+			return true
+		}
+	}
+	return a.opt.Sample > 0 && a.opt.Sample < 1 && rand.Float64() >= a.opt.Sample
+}
+
+func (a *AccessLogger) Log(w http.ResponseWriter, r *http.Request, statuscode int, source string, elapsed time.Duration) {
+	rec := AccessRecord{
+		Time:      time.Now().Add(-elapsed),
+		Remote:    r.RemoteAddr,
+		Proxy:     request_peer(r),
+		Scheme:    request_scheme(r),
+		User:      r.URL.User.Username(),
+		Method:    r.Method,
+		URI:       r.URL.RequestURI(),
+		Path:      r.URL.Path,
+		Proto:     r.Proto,
+		Host:      r.Host,
+		Status:    statuscode,
+		Source:    source,
+		Length:    -1,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		Elapsed:   elapsed,
+		Header:    w.Header(),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		rec.Remote = host
+	}
+	if val, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+		rec.Length = val
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.tmpl.Execute(a.out, rec); err != nil {
+		slog.Error("access log", "error", err)
+	}
+}
+
+// Reopen reopens log file
+func (a *AccessLogger) Reopen() error {
+	if lf, ok := a.out.(*logFile); ok {
+		slog.Info("reopen access log", "name", lf.name)
+		return lf.Reopen()
+	}
+	return nil
+}
+
+func (a *AccessLogger) Close() error {
+	if c, ok := a.out.(io.Closer); ok && a.out != os.Stdout && a.out != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+// access_attrs makes slog attributes of the request
+func access_attrs(w http.ResponseWriter, r *http.Request, statuscode int, source string, elapsed time.Duration) []any {
+	headers := []any{
+		"remote", r.RemoteAddr, "elapsed", elapsed,
+		"method", r.Method, "path", r.URL.Path,
+		"status", statuscode, "protocol", r.Proto,
+		"scheme", request_scheme(r),
+	}
+	if source != "" {
+		headers = append(headers, "source", source)
+	}
+	if peer := request_peer(r); peer != "" {
+		headers = append(headers, "proxy", peer)
+	}
+	if r.URL.User.Username() != "" {
+		headers = append(headers, "user", r.URL.User.Username())
+	}
+	for k, v := range w.Header() {
+		switch strings.ToLower(k) {
+		case "etag", "content-type", "content-encoding", "location":
+			headers = append(headers, strings.ToLower(k), v[0])
+		case "content-length":
+			if val, err := strconv.Atoi(v[0]); err != nil {
+				headers = append(headers, "length", v[0])
+			} else {
+				headers = append(headers, "length", val)
+			}
+		case "last-modified":
+			if ts, err := time.Parse(http.TimeFormat, v[0]); err != nil {
+				headers = append(headers, "last-modified", v[0])
+			} else {
+				headers = append(headers, "last-modified", ts)
+			}
+		}
+	}
+	for k, v := range r.Header {
+		switch strings.ToLower(k) {
+		case "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto":
+			headers = append(headers, strings.TrimPrefix(strings.ToLower(k), "x-"), v[0])
+		case "forwarded", "user-agent", "if-none-match", "referer", "accept-encoding", "range":
+			headers = append(headers, strings.ToLower(k), v[0])
+		case "if-modified-since":
+			if ts, err := time.Parse(http.TimeFormat, v[0]); err != nil {
+				headers = append(headers, "if-modified-since", v[0])
+			} else {
+				headers = append(headers, "if-modified-since", ts)
+			}
+		}
+	}
+	return headers
+}
+
+// log_access writes access log of the request
+func (h *ZipHandler) log_access(w http.ResponseWriter, r *http.Request, statuscode int, source string, elapsed time.Duration) {
+	if h.access != nil {
+		if h.access.skip(r) {
+			return
+		}
+		if h.access.tmpl != nil {
+			h.access.Log(w, r, statuscode, source, elapsed)
+			return
+		}
+	}
+	if h.accesslog != nil {
+		h.accesslog.Info(http.StatusText(statuscode), access_attrs(w, r, statuscode, source, elapsed)...)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func open_syslog(spec string) (io.Writer, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// open_syslog connects to syslog. "syslog" for local, "syslog:udp:host:514" for remote
+func open_syslog(spec string) (io.Writer, error) {
+	network, addr := "", ""
+	if _, rest, ok := strings.Cut(spec, ":"); ok {
+		network, addr, _ = strings.Cut(rest, ":")
+	}
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "ziphttp")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func accesslog_handler(t *testing.T, opt AccessLogOption) (*ZipHandler, string) {
+	t.Helper()
+	opt.Output = filepath.Join(t.TempDir(), "access.log")
+	access, err := NewAccessLogger(opt)
+	if err != nil {
+		t.Fatal("logger", err)
+	}
+	t.Cleanup(func() { access.Close() })
+	hdl := &ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		access:    access,
+	}
+	if access.tmpl == nil {
+		hdl.accesslog = access.logger()
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Fatal("initialize", err)
+	}
+	return hdl, opt.Output
+}
+
+func accesslog_get(hdl *ZipHandler, path string) {
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+path, bytes.NewBuffer([]byte{}))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test-agent")
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	t.Parallel()
+	hdl, output := accesslog_handler(t, AccessLogOption{Format: "combined", Exclude: []string{"/healthz"}})
+	accesslog_get(hdl, "/512b.txt")
+	accesslog_get(hdl, "/healthz")
+	accesslog_get(hdl, "/notfound")
+	buf, err := os.ReadFile(output)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	if len(lines) != 2 {
+		t.Error("lines", len(lines), string(buf))
+		return
+	}
+	if !strings.HasPrefix(lines[0], "192.0.2.1 - - [") || !strings.HasSuffix(lines[0], `] "GET /512b.txt HTTP/1.1" 200 512 "http://example.com/" "test-agent"`) {
+		t.Error("line", lines[0])
+	}
+	if !strings.Contains(lines[1], `"GET /notfound HTTP/1.1" 404 `) {
+		t.Error("line", lines[1])
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	t.Parallel()
+	hdl, output := accesslog_handler(t, AccessLogOption{Format: "json"})
+	accesslog_get(hdl, "/512b.txt")
+	buf, err := os.ReadFile(output)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		t.Error("unmarshal", err, string(buf))
+		return
+	}
+	if rec["type"] != "accesslog" || rec["path"] != "/512b.txt" || rec["status"] != float64(200) {
+		t.Error("record", rec)
+	}
+}
+
+func TestAccessLogTemplate(t *testing.T) {
+	t.Parallel()
+	hdl, output := accesslog_handler(t, AccessLogOption{Format: "template", Template: `{{.Status}} {{.Path}} {{.Header.Get "Content-Type"}}`})
+	accesslog_get(hdl, "/512b.txt")
+	buf, err := os.ReadFile(output)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	if string(buf) != "200 /512b.txt text/plain; charset=utf-8\n" {
+		t.Error("output", string(buf))
+	}
+	if _, err := NewAccessLogger(AccessLogOption{Format: "template", Template: "{{"}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestAccessLogSample(t *testing.T) {
+	t.Parallel()
+	access := AccessLogger{opt: AccessLogOption{Sample: 0.5}}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", nil)
+	logged := 0
+	for range 1000 {
+		if !access.skip(req) {
+			logged++
+		}
+	}
+	if logged < 300 || logged > 700 {
+		t.Error("sampled", logged)
+	}
+}
+
+func TestLogFileRotate(t *testing.T) {
+	t.Parallel()
+	name := filepath.Join(t.TempDir(), "access.log")
+	lf, err := openLogFile(name, 10, 0)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer lf.Close()
+	for _, s := range []string{"12345678\n", "abcdefgh\n"} {
+		if _, err := lf.Write([]byte(s)); err != nil {
+			t.Error("write", err)
+		}
+	}
+	if buf, err := os.ReadFile(name); err != nil || string(buf) != "abcdefgh\n" {
+		t.Error("current", string(buf), err)
+	}
+	rotated, _ := filepath.Glob(name + ".*")
+	if len(rotated) != 1 {
+		t.Error("rotated", rotated)
+	}
+	if err := os.Rename(name, name+".old"); err != nil {
+		t.Error("rename", err)
+	}
+	if err := lf.Reopen(); err != nil {
+		t.Error("reopen", err)
+	}
+	if _, err := lf.Write([]byte("reopened\n")); err != nil {
+		t.Error("write", err)
+	}
+	if buf, err := os.ReadFile(name); err != nil || string(buf) != "reopened\n" {
+		t.Error("reopened", string(buf), err)
+	}
+}
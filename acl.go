@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// ACLRule allows or denies client networks for path. path is prefix, or glob if it has meta characters
+type ACLRule struct {
+	Path  string   `json:"path"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// match_path matches urlpath by prefix, or glob if pattern has meta characters.
+// trailing "*" also matches subtree: "/drafts/*" matches "/drafts/a/b.html"
+func match_path(pattern string, urlpath string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return strings.HasPrefix(urlpath, pattern)
+	}
+	if strings.HasSuffix(pattern, "*") {
+		// compare same number of segments as pattern
+		segments := strings.Count(pattern, "/")
+		for i, c := range urlpath {
+			if c != '/' {
+				continue
+			}
+			if segments == 0 {
+				urlpath = urlpath[:i]
+				break
+			}
+			segments--
+		}
+	}
+	matched, _ := path.Match(pattern, urlpath)
+	return matched
+}
+
+// clean_path resolves "//", "." and ".." of urlpath as filename does. trailing "/" is kept
+func clean_path(urlpath string) string {
+	res := path.Clean("/" + urlpath)
+	if strings.HasSuffix(urlpath, "/") && res != "/" {
+		res += "/"
+	}
+	return res
+}
+
+// check_path_pattern returns error if pattern is invalid glob
+func check_path_pattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%s: %w", pattern, err)
+	}
+	return nil
+}
+
+// permit returns false if ip is denied, or allow list exists and ip is not in it
+func (rule *ACLRule) permit(ip net.IP) bool {
+	if ip == nil {
+		return len(rule.allow) == 0 && len(rule.deny) == 0
+	}
+	if contains_ip(rule.deny, ip) {
+		return false
+	}
+	return len(rule.allow) == 0 || contains_ip(rule.allow, ip)
+}
+
+func LoadACLRules(filename string) ([]ACLRule, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var res []ACLRule
+	if err = json.NewDecoder(fp).Decode(&res); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		if err = check_path_pattern(res[i].Path); err != nil {
+			return nil, err
+		}
+		if res[i].allow, err = parse_networks(res[i].Allow); err != nil {
+			return nil, err
+		}
+		if res[i].deny, err = parse_networks(res[i].Deny); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ACL is rules read from file. rules are replaced on reload
+type ACL struct {
+	filename string
+	rules    atomic.Pointer[[]ACLRule]
+}
+
+func NewACL(filename string) (*ACL, error) {
+	res := ACL{filename: filename}
+	if err := res.Reload(); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Reload reads rules from file. current rules are kept if failed
+func (acl *ACL) Reload() error {
+	rules, err := LoadACLRules(acl.filename)
+	if err != nil {
+		return err
+	}
+	slog.Info("acl loaded", "name", acl.filename, "rules", len(rules))
+	acl.rules.Store(&rules)
+	return nil
+}
+
+// Permit evaluates first rule matches path
+func (acl *ACL) Permit(r *http.Request) bool {
+	rules := acl.rules.Load()
+	if rules == nil {
+		return true
+	}
+	urlpath := clean_path(r.URL.Path)
+	for i := range *rules {
+		rule := &(*rules)[i]
+		if match_path(rule.Path, urlpath) {
+			return rule.permit(remote_ip(r))
+		}
+	}
+	return true
+}
+
+// deny_request responds 403 if client is not permitted
+func (acl *ACL) deny_request(w http.ResponseWriter, r *http.Request, statuscode *int) bool {
+	if acl.Permit(r) {
+		return false
+	}
+	*statuscode = http.StatusForbidden
+	slog.Debug("acl denied", "remote", r.RemoteAddr, "path", r.URL.Path)
+	http.Error(w, http.StatusText(*statuscode), *statuscode)
+	return true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	t.Parallel()
+	filename := filepath.Join(t.TempDir(), "acl.json")
+	rules := `[
+		{"path": "/4kb.txt", "allow": ["10.0.0.0/8"], "deny": ["10.0.0.9"]},
+		{"path": "/*.txt", "deny": ["192.0.2.0/24"]}
+	]`
+	if err := os.WriteFile(filename, []byte(rules), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	acl, err := NewACL(filename)
+	if err != nil {
+		t.Error("load", err)
+		return
+	}
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		acl:       acl,
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	check := func(remote string, path string, status int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+path, bytes.NewBuffer([]byte{}))
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		hdl.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Error("status", remote, path, w.Code)
+		}
+	}
+	check("10.0.0.1:1234", "/4kb.txt", http.StatusOK)
+	check("10.0.0.9:1234", "/4kb.txt", http.StatusForbidden)
+	check("192.0.2.1:1234", "/4kb.txt", http.StatusForbidden)
+	check("192.0.2.1:1234", "/512b.txt", http.StatusForbidden)
+	check("198.51.100.1:1234", "/512b.txt", http.StatusOK)
+	check("10.0.0.9:1234", "//4kb.txt", http.StatusForbidden)
+	check("10.0.0.9:1234", "/./4kb.txt", http.StatusForbidden)
+	check("10.0.0.9:1234", "/x/../4kb.txt", http.StatusForbidden)
+
+	if err := os.WriteFile(filename, []byte(`[{"path": "/", "allow": ["invalid"]}]`), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	if err := acl.Reload(); err == nil {
+		t.Error("no error")
+	}
+	check("192.0.2.1:1234", "/512b.txt", http.StatusForbidden)
+	if err := os.WriteFile(filename, []byte(`[{"path": "/4kb", "allow": ["192.0.2.0/24"]}]`), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	if err := acl.Reload(); err != nil {
+		t.Error("reload", err)
+	}
+	check("192.0.2.1:1234", "/512b.txt", http.StatusOK)
+	check("10.0.0.1:1234", "/4kb.txt", http.StatusForbidden)
+	if err := os.WriteFile(filename, []byte(`[{"path": "/[", "allow": ["192.0.2.0/24"]}]`), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	if err := acl.Reload(); err == nil {
+		t.Error("invalid pattern is loaded")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		pattern  string
+		urlpath  string
+		expected bool
+	}{
+		{"/drafts/", "/drafts/a/b.html", true},
+		{"/drafts/", "/draft", false},
+		{"/drafts/*", "/drafts/", true},
+		{"/drafts/*", "/drafts/a.html", true},
+		{"/drafts/*", "/drafts/a/b/c.html", true},
+		{"/drafts/*", "/drafts", false},
+		{"/d*", "/drafts/a.html", true},
+		{"/*/private/*", "/a/private/b/c", true},
+		{"/*/private/*", "/a/public/b", false},
+		{"/*.txt", "/a.txt", true},
+		{"/*.txt", "/a/b.txt", false},
+	} {
+		if res := match_path(tc.pattern, tc.urlpath); res != tc.expected {
+			t.Error("match", tc.pattern, tc.urlpath, res)
+		}
+	}
+}
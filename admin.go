@@ -0,0 +1,84 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+)
+
+// send_health responds liveness
+func send_health(w http.ResponseWriter, r *http.Request, statuscode *int) {
+	*statuscode = http.StatusOK
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(*statuscode)
+	if r.Method != http.MethodHead {
+		fmt.Fprint(w, "ok")
+	}
+}
+
+// send_ready responds readiness. not ready while draining
+func (h *ZipHandler) send_ready(w http.ResponseWriter, r *http.Request, statuscode *int) {
+	if !h.draining.Load() {
+		send_health(w, r, statuscode)
+		return
+	}
+	*statuscode = http.StatusServiceUnavailable
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(*statuscode)
+	if r.Method != http.MethodHead {
+		fmt.Fprint(w, "draining")
+	}
+}
+
+// handle_health responds if path is health or readiness endpoint
+func (h *ZipHandler) handle_health(w http.ResponseWriter, r *http.Request, statuscode *int) bool {
+	switch {
+	case slices.Contains(h.healthpaths, r.URL.Path):
+		send_health(w, r, statuscode)
+	case slices.Contains(h.readypaths, r.URL.Path):
+		h.send_ready(w, r, statuscode)
+	default:
+		return false
+	}
+	return true
+}
+
+// admin_handler serves /healthz, /readyz, /maintenance and /debug/vars
+func (cmd *WebServer) admin_handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		statuscode := 0
+		send_health(w, r, &statuscode)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		statuscode := 0
+		cmd.handler.send_ready(w, r, &statuscode)
+	})
+	mux.Handle("/maintenance", cmd.handler.maintenance)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// start_admin starts admin server
+func (cmd *WebServer) start_admin() error {
+	listener, err := do_listen(cmd.AdminListen)
+	if err != nil {
+		return err
+	}
+	cmd.admin = http.Server{
+		Handler:           cmd.admin_handler(),
+		ReadHeaderTimeout: cmd.ReadHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
+	}
+	slog.Info("admin server starting", "listen", listener.Addr())
+	go func() {
+		if err := cmd.admin.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("admin server", "error", err)
+		}
+	}()
+	return nil
+}
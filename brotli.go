@@ -22,4 +22,7 @@ func init() {
 	zip.RegisterDecompressor(Brotli, func(input io.Reader) io.ReadCloser {
 		return io.NopCloser(brotli.NewReader(input))
 	})
+	dynamic_compressors["br"] = func(out io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriter(out), nil
+	}
 }
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prefix of environment variables to override options
+const envPrefix = "ZIPHTTP_"
+
+// configSections maps section of config file to commands. top level keys are global options
+var configSections = map[string][]string{
+	"webserver": {"webserver", "config"},
+}
+
+// selfConfigMark is first line of archive comment with server config. the comment is also valid YAML
+const selfConfigMark = "# ziphttp server config\n"
+
+// commandArgs is arguments parsed at startup, to parse again on reload
+var commandArgs []string
+
+// config_source finds --config and --self in args before parsing
+func config_source(args []string) (string, bool) {
+	var pre struct {
+		Config string `long:"config" env:"ZIPHTTP_CONFIG"`
+		Self   bool   `long:"self" env:"ZIPHTTP_SELF"`
+	}
+	_, _ = flags.NewParser(&pre, flags.IgnoreUnknown|flags.AllowBoolValues).ParseArgs(args)
+	return pre.Config, pre.Self
+}
+
+// load_config reads TOML(*.toml) or YAML file
+func load_config(name string) (map[string]any, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]any{}
+	if strings.EqualFold(filepath.Ext(name), ".toml") {
+		err = toml.Unmarshal(data, &res)
+	} else {
+		err = yaml.Unmarshal(data, &res)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return res, nil
+}
+
+// merge_config overrides dst by src. sections are merged by key
+func merge_config(dst, src map[string]any) {
+	for key, value := range src {
+		section, ok1 := value.(map[string]any)
+		current, ok2 := dst[key].(map[string]any)
+		if ok1 && ok2 {
+			maps.Copy(current, section)
+		} else {
+			dst[key] = value
+		}
+	}
+}
+
+// read_self_config reads server config in archive comment. nil if not embedded
+func read_self_config(name string) (map[string]any, error) {
+	rd, err := zip.OpenReader(name)
+	if errors.Is(err, zip.ErrFormat) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	if !strings.HasPrefix(rd.Comment, selfConfigMark) {
+		return nil, nil
+	}
+	res := map[string]any{}
+	if err = yaml.Unmarshal([]byte(rd.Comment), &res); err != nil {
+		return nil, fmt.Errorf("embedded config of %s: %w", name, err)
+	}
+	return res, nil
+}
+
+// server_config makes server config to embed from config file and KEY=VALUE options of webserver
+func server_config(name string, options []string) (map[string]any, error) {
+	conf := map[string]any{}
+	if name != "" {
+		var err error
+		if conf, err = load_config(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(options) != 0 {
+		section, ok := conf["webserver"].(map[string]any)
+		if !ok {
+			section = map[string]any{}
+			conf["webserver"] = section
+		}
+		values := map[string][]any{}
+		for _, opt := range options {
+			// KEY without value is bool option
+			key, value, ok := strings.Cut(opt, "=")
+			if !ok {
+				value = "true"
+			}
+			values[key] = append(values[key], value)
+		}
+		for key, v := range values {
+			if len(v) == 1 {
+				section[key] = v[0]
+			} else {
+				section[key] = v
+			}
+		}
+	}
+	for _, key := range []string{"archive", "self", "config"} {
+		if _, ok := conf[key]; ok {
+			return nil, fmt.Errorf("%s cannot be embedded", key)
+		}
+	}
+	var global GlobalOption
+	parser := flags.NewParser(&global, flags.None)
+	if _, err := parser.AddCommand("webserver", "", "", &WebServer{}); err != nil {
+		return nil, err
+	}
+	if err := apply_config(parser, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// self_config_comment makes archive comment of server config
+func self_config_comment(conf map[string]any) (string, error) {
+	buf := bytes.NewBufferString(selfConfigMark)
+	if err := write_config(buf, "yaml", conf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// self_args runs webserver --self if no command is specified and executable has server config
+func self_args(parser *flags.Parser, args []string, exe string) []string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") && parser.Find(arg) != nil {
+			return args
+		}
+	}
+	if conf, err := read_self_config(exe); err != nil || conf == nil {
+		return args
+	}
+	return append([]string{"--self", "webserver"}, args...)
+}
+
+// config_strings converts value of config file to default values of option
+func config_strings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		res := make([]string, 0, len(v))
+		for _, elem := range v {
+			switch elem.(type) {
+			case []any, map[string]any:
+				return nil, errors.New("nested value is not supported")
+			}
+			res = append(res, fmt.Sprint(elem))
+		}
+		return res, nil
+	case map[string]any:
+		// "key:value" as map option and --header
+		res := make([]string, 0, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			res = append(res, fmt.Sprintf("%s:%v", key, v[key]))
+		}
+		return res, nil
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
+
+// apply_options sets values of config file as defaults of options
+func apply_options(group *flags.Group, conf map[string]any, prefix string) error {
+	for _, key := range slices.Sorted(maps.Keys(conf)) {
+		option := group.FindOptionByLongName(key)
+		if option == nil {
+			return fmt.Errorf("unknown option: %s%s", prefix, key)
+		}
+		values, err := config_strings(conf[key])
+		if err != nil {
+			return fmt.Errorf("%s%s: %w", prefix, key, err)
+		}
+		if len(option.Choices) != 0 {
+			for _, v := range values {
+				if !slices.Contains(option.Choices, v) {
+					return fmt.Errorf("%s%s: invalid value %s, allowed values are %s", prefix, key, v, strings.Join(option.Choices, ", "))
+				}
+			}
+		}
+		option.Default = values
+	}
+	return nil
+}
+
+// apply_config sets values of config file as defaults of global options and command options
+func apply_config(parser *flags.Parser, conf map[string]any) error {
+	global := map[string]any{}
+	for key, value := range conf {
+		names, ok := configSections[key]
+		if !ok {
+			global[key] = value
+			continue
+		}
+		section, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be a table", key)
+		}
+		for _, name := range names {
+			if cmd := parser.Find(name); cmd != nil {
+				if err := apply_options(cmd.Group, section, key+"."); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return apply_options(parser.Group, global, "")
+}
+
+// set_env_keys enables ZIPHTTP_<LONG_NAME> of options. lists are separated by comma
+func set_env_keys(group *flags.Group) {
+	for _, option := range group.Options() {
+		if option.EnvDefaultKey != "" || option.LongName == "" {
+			continue
+		}
+		option.EnvDefaultKey = envPrefix + strings.ToUpper(strings.ReplaceAll(option.LongName, "-", "_"))
+		if option.Field().Type.Kind() == reflect.Slice {
+			option.EnvDefaultDelim = ","
+		}
+	}
+	for _, g := range group.Groups() {
+		set_env_keys(g)
+	}
+}
+
+// setup_config layers options: flags > env(ZIPHTTP_*) > config file > config embedded in --self > defaults
+func setup_config(parser *flags.Parser, args []string) error {
+	set_env_keys(parser.Group)
+	for _, names := range configSections {
+		for _, name := range names {
+			if cmd := parser.Find(name); cmd != nil {
+				set_env_keys(cmd.Group)
+			}
+		}
+	}
+	name, self := config_source(args)
+	conf := map[string]any{}
+	if self {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		embedded, err := read_self_config(exe)
+		if err != nil {
+			slog.Warn("embedded config", "name", exe, "error", err)
+		} else if embedded != nil {
+			slog.Debug("embedded config", "name", exe)
+			conf = embedded
+		}
+	}
+	if name != "" {
+		file, err := load_config(name)
+		if err != nil {
+			return err
+		}
+		merge_config(conf, file)
+	}
+	return apply_config(parser, conf)
+}
+
+// config_values returns options by long name
+func config_values(data any) map[string]any {
+	res := map[string]any{}
+	collect_values(reflect.Indirect(reflect.ValueOf(data)), res)
+	return res
+}
+
+func collect_values(v reflect.Value, res map[string]any) {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if long := field.Tag.Get("long"); long != "" {
+			res[long] = config_value(v.Field(i))
+		} else if field.Type.Kind() == reflect.Struct {
+			collect_values(v.Field(i), res)
+		}
+	}
+}
+
+func config_value(v reflect.Value) any {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		res := make([]any, v.Len())
+		for i := range v.Len() {
+			res[i] = config_value(v.Index(i))
+		}
+		return res
+	}
+	return v.Interface()
+}
+
+// omit_secrets removes options tagged secret:"true" from config to show
+func omit_secrets(conf map[string]any) {
+	section, _ := conf["webserver"].(map[string]any)
+	for _, field := range reflect.VisibleFields(reflect.TypeFor[WebServer]()) {
+		long := field.Tag.Get("long")
+		if field.Tag.Get("secret") != "true" || long == "" {
+			continue
+		}
+		for _, m := range []map[string]any{conf, section} {
+			if v, ok := m[long]; ok && v != "" {
+				slog.Info("secret option is not shown", "option", long)
+			}
+			delete(m, long)
+		}
+	}
+}
+
+// write_config writes config in format of config file
+func write_config(w io.Writer, format string, conf map[string]any) error {
+	if format == "toml" {
+		return toml.NewEncoder(w).Encode(conf)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(conf); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// reload_config parses config file, env and args again, and applies options changeable at runtime
+func (cmd *WebServer) reload_config() error {
+	if globalOption.Config == "" {
+		return nil
+	}
+	var global GlobalOption
+	var next WebServer
+	parser := flags.NewParser(&global, flags.AllowBoolValues)
+	parser.CommandHandler = func(flags.Commander, []string) error { return nil }
+	if _, err := parser.AddCommand("webserver", "", "", &next); err != nil {
+		return err
+	}
+	if err := setup_config(parser, commandArgs); err != nil {
+		return err
+	}
+	if _, err := parser.ParseArgs(commandArgs); err != nil {
+		return err
+	}
+	if err := next.validate(); err != nil {
+		return err
+	}
+	slog.Info("reloading config", "name", globalOption.Config)
+	oldglobal, newglobal := config_values(&globalOption), config_values(&global)
+	for _, key := range slices.Sorted(maps.Keys(newglobal)) {
+		if reflect.DeepEqual(oldglobal[key], newglobal[key]) {
+			continue
+		}
+		switch key {
+		case "verbose", "quiet":
+			slog.Info("config changed", "option", key, "value", newglobal[key])
+		default:
+			slog.Warn("config change requires restart", "option", key)
+		}
+	}
+	globalOption.Verbose, globalOption.Quiet = global.Verbose, global.Quiet
+	init_log()
+	oldopts, newopts := config_values(cmd), config_values(&next)
+	for _, key := range slices.Sorted(maps.Keys(newopts)) {
+		if reflect.DeepEqual(oldopts[key], newopts[key]) {
+			continue
+		}
+		switch key {
+		case "header":
+			headers, _ := parse_headers(next.Headers)
+			cmd.handler.rwlock.Lock()
+			cmd.handler.headers = headers
+			cmd.handler.rwlock.Unlock()
+			cmd.Headers = next.Headers
+		case "maintenance":
+			cmd.handler.maintenance.Set(next.Maintenance)
+			cmd.Maintenance = next.Maintenance
+		default:
+			slog.Warn("config change requires restart", "option", key)
+			continue
+		}
+		slog.Info("config changed", "option", key, "value", newopts[key])
+	}
+	return nil
+}
+
+// ConfigCmd shows configuration of webserver merged from config file, env and flags
+type ConfigCmd struct {
+	Format   string `long:"format" choice:"yaml" choice:"toml" default:"yaml" description:"output format"`
+	Embedded bool   `long:"embedded" description:"show server config embedded in archive(default: executable) by zip --server-config/--server-option"`
+	WebServer
+}
+
+func (cmd *ConfigCmd) Execute(args []string) error {
+	init_log()
+	if cmd.Embedded {
+		name := archiveFilename()
+		if name == "" {
+			var err error
+			if name, err = os.Executable(); err != nil {
+				return err
+			}
+		}
+		conf, err := read_self_config(name)
+		if err != nil {
+			slog.Error("embedded config", "name", name, "error", err)
+			return err
+		}
+		if conf == nil {
+			slog.Error("no embedded config", "name", name)
+			return errors.New("no embedded config")
+		}
+		omit_secrets(conf)
+		return write_config(os.Stdout, cmd.Format, conf)
+	}
+	if err := cmd.validate(); err != nil {
+		slog.Error("invalid config", "error", err)
+		return err
+	}
+	conf := config_values(&globalOption)
+	delete(conf, "config")
+	conf["webserver"] = config_values(&cmd.WebServer)
+	omit_secrets(conf)
+	return write_config(os.Stdout, cmd.Format, conf)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func parse_config_test(t *testing.T, conf string, ext string, args []string) (*GlobalOption, *WebServer, error) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "ziphttp"+ext)
+	if err := os.WriteFile(name, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var global GlobalOption
+	var ws WebServer
+	parser := flags.NewParser(&global, flags.AllowBoolValues)
+	parser.CommandHandler = func(flags.Commander, []string) error { return nil }
+	if _, err := parser.AddCommand("webserver", "", "", &ws); err != nil {
+		t.Fatal(err)
+	}
+	args = append([]string{"--config", name}, args...)
+	if err := setup_config(parser, args); err != nil {
+		return nil, nil, err
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, nil, err
+	}
+	return &global, &ws, nil
+}
+
+func TestConfigYAML(t *testing.T) {
+	t.Setenv("ZIPHTTP_READ_TIMEOUT", "3s")
+	conf := `
+verbose: true
+archive: site.zip
+webserver:
+  listen: [":8080", "unix:/tmp/ziphttp.sock"]
+  index: default.html
+  read-timeout: 5s
+  write-timeout: 1m
+  rate-limit: 2.5
+  access-log-format: combined
+  header:
+    X-Frame-Options: DENY
+    Cache-Control: max-age=60, public
+`
+	global, ws, err := parse_config_test(t, conf, ".yaml", []string{"webserver", "--index", "flag.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !global.Verbose || global.Archive != "site.zip" {
+		t.Error("global", global)
+	}
+	if len(ws.Listen) != 2 || ws.Listen[1] != "unix:/tmp/ziphttp.sock" {
+		t.Error("listen", ws.Listen)
+	}
+	if ws.IndexFilename != "flag.html" {
+		t.Error("flag should override file", ws.IndexFilename)
+	}
+	if ws.ReadTimeout != 3*time.Second {
+		t.Error("env should override file", ws.ReadTimeout)
+	}
+	if ws.WriteTimeout != time.Minute || ws.RateLimit != 2.5 || ws.AccessLogFormat != "combined" {
+		t.Error("file", ws.WriteTimeout, ws.RateLimit, ws.AccessLogFormat)
+	}
+	if ws.IdleTimeout != 10*time.Second {
+		t.Error("default", ws.IdleTimeout)
+	}
+	headers, err := parse_headers(ws.Headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["X-Frame-Options"] != "DENY" || headers["Cache-Control"] != "max-age=60, public" {
+		t.Error("header", headers)
+	}
+}
+
+func TestConfigTOML(t *testing.T) {
+	t.Setenv("ZIPHTTP_CORS_ORIGIN", "https://a.example.com,https://b.example.com")
+	conf := `
+quiet = true
+
+[webserver]
+pin-budget = 1048576
+dynamic-type = ["text/html"]
+maintenance = true
+`
+	global, ws, err := parse_config_test(t, conf, ".toml", []string{"webserver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !global.Quiet {
+		t.Error("quiet")
+	}
+	if ws.PinBudget != 1048576 || !ws.Maintenance {
+		t.Error("file", ws.PinBudget, ws.Maintenance)
+	}
+	if len(ws.DynamicTypes) != 1 || ws.DynamicTypes[0] != "text/html" {
+		t.Error("file should replace default", ws.DynamicTypes)
+	}
+	if len(ws.CORSOrigin) != 2 || ws.CORSOrigin[1] != "https://b.example.com" {
+		t.Error("env list", ws.CORSOrigin)
+	}
+}
+
+func TestConfigBoolOverride(t *testing.T) {
+	t.Setenv("ZIPHTTP_MAINTENANCE", "false")
+	conf := "webserver:\n  directory-redirect: true\n  maintenance: true\n  support-gz: true\n"
+	_, ws, err := parse_config_test(t, conf, ".yaml", []string{"webserver", "--directory-redirect=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.DirRedirect || ws.Maintenance || !ws.SupportGzip {
+		t.Error("bool", ws.DirRedirect, ws.Maintenance, ws.SupportGzip)
+	}
+	if _, _, err = parse_config_test(t, conf, ".yaml", []string{"webserver", "--directory-redirect=maybe"}); err == nil {
+		t.Error("invalid bool")
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, conf := range []string{
+		"unknown: 1\n",
+		"webserver:\n  unknown: 1\n",
+		"webserver: 1\n",
+		"webserver:\n  access-log-format: xml\n",
+		"webserver:\n  listen: [[a]]\n",
+		"webserver: [\n",
+	} {
+		if _, _, err := parse_config_test(t, conf, ".yml", []string{"webserver"}); err == nil {
+			t.Error("no error", conf)
+		}
+	}
+	if _, _, err := parse_config_test(t, "webserver:\n  read-timeout: abc\n", ".yml", []string{"webserver"}); err == nil {
+		t.Error("invalid duration")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	ws := WebServer{ProxyProtocol: true}
+	if err := ws.validate(); err == nil {
+		t.Error("proxy protocol without trusted proxy")
+	}
+	ws = WebServer{Headers: []string{"no-colon"}}
+	if err := ws.validate(); err == nil {
+		t.Error("invalid header")
+	}
+	ws = WebServer{MaintenanceAllow: []string{"10.0.0.0/33"}}
+	if err := ws.validate(); err == nil {
+		t.Error("invalid cidr")
+	}
+	ws = WebServer{Headers: []string{"X-A: b"}, TrustedProxy: []string{"10.0.0.0/8"}, ProxyProtocol: true}
+	if err := ws.validate(); err != nil {
+		t.Error("valid", err)
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "ziphttp.yaml")
+	if err := os.WriteFile(name, []byte("webserver:\n  listen: [':8080']\n  drain-timeout: 1m\n  debug-secret: s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _ := runcmd_test(t, []string{"ziphttp", "--config", name, "config", "--index", "top.html"}, 0)
+	for _, s := range []string{"webserver:", "- :8080", "drain-timeout: 1m0s", "index: top.html", "verbose: false"} {
+		if !strings.Contains(stdout, s) {
+			t.Error("missing", s)
+		}
+	}
+	if strings.Contains(stdout, "s3cret") || strings.Contains(stdout, "debug-secret") {
+		t.Error("secret is shown", stdout)
+	}
+	// output can be read as config file
+	global, ws, err := parse_config_test(t, stdout, ".yaml", []string{"webserver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.Verbose || ws.IndexFilename != "top.html" || ws.DrainTimeout != time.Minute || len(ws.DynamicTypes) != 5 {
+		t.Error("reload", ws.IndexFilename, ws.DrainTimeout, ws.DynamicTypes)
+	}
+	stdout, _ = runcmd_test(t, []string{"ziphttp", "--config", name, "config", "--format", "toml"}, 0)
+	if !strings.Contains(stdout, "[webserver]") || !strings.Contains(stdout, `listen = [":8080"]`) {
+		t.Error("toml", stdout)
+	}
+	runcmd_test(t, []string{"ziphttp", "--config", name, "config", "--proxy-protocol"}, 1)
+	runcmd_test(t, []string{"ziphttp", "--config", filepath.Join(t.TempDir(), "notfound.yaml"), "config"}, 1)
+}
+
+func TestConfigReload(t *testing.T) {
+	oldglobal, oldargs := globalOption, commandArgs
+	defer func() {
+		globalOption, commandArgs = oldglobal, oldargs
+	}()
+	name := filepath.Join(t.TempDir(), "ziphttp.yaml")
+	if err := os.WriteFile(name, []byte("webserver:\n  header: ['X-A: 1']\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	globalOption = GlobalOption{Config: flags.Filename(name)}
+	commandArgs = []string{"--config", name, "webserver"}
+	cmd := WebServer{Listen: []string{":3000"}, Headers: []string{"X-A: 1"}}
+	cmd.handler.headers = map[string]string{"X-A": "1"}
+	cmd.handler.maintenance = NewMaintenance(MaintenanceOption{}, false)
+	conf := "webserver:\n  header: ['X-A: 2']\n  maintenance: true\n  listen: [':8080']\n"
+	if err := os.WriteFile(name, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.reload_config(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.handler.headers["X-A"] != "2" || !cmd.handler.maintenance.Enabled() {
+		t.Error("not applied", cmd.handler.headers, cmd.handler.maintenance.Enabled())
+	}
+	if cmd.Listen[0] != ":3000" {
+		t.Error("listen requires restart", cmd.Listen)
+	}
+	if err := os.WriteFile(name, []byte("webserver:\n  header: ['invalid']\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.reload_config(); err == nil {
+		t.Error("invalid config is applied")
+	}
+	if cmd.handler.headers["X-A"] != "2" {
+		t.Error("current options should be kept", cmd.handler.headers)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "server.toml")
+	if err := os.WriteFile(name, []byte("verbose = true\n[webserver]\nindex = \"top.html\"\nlisten = [\":80\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := server_config(name, []string{"listen=:8888", "listen=:8889", "directory-redirect", "header=X-A: b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := conf["webserver"].(map[string]any)
+	if conf["verbose"] != true || section["index"] != "top.html" || section["directory-redirect"] != "true" || section["header"] != "X-A: b" {
+		t.Error("config", conf)
+	}
+	if listen, ok := section["listen"].([]any); !ok || len(listen) != 2 || listen[1] != ":8889" {
+		t.Error("option should override file", section["listen"])
+	}
+	for _, opts := range [][]string{{"unknown=1"}, {"access-log-format=xml"}} {
+		if _, err := server_config("", opts); err == nil {
+			t.Error("invalid option is embedded", opts)
+		}
+	}
+	if err := os.WriteFile(name, []byte("archive = \"other.zip\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server_config(name, nil); err == nil {
+		t.Error("archive is embedded")
+	}
+}
+
+func TestSelfConfig(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	output := filepath.Join(t.TempDir(), "output.zip")
+	globalOption.Archive = flags.Filename(output)
+	zz := ZipCmd{Method: "deflate", MinSize: 512, ServerOpts: []string{"listen=:8888", "index=top.html", "debug-secret=hidden"}}
+	if err := zz.Execute([]string{prepare_testzip(t)}); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := read_self_config(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if section, ok := conf["webserver"].(map[string]any); !ok || section["listen"] != ":8888" {
+		t.Error("embedded", conf)
+	}
+	if conf, err = read_self_config(prepare_testzip(t)); err != nil || conf != nil {
+		t.Error("no embedded config", conf, err)
+	}
+	var global GlobalOption
+	var ws WebServer
+	parser := flags.NewParser(&global, flags.None)
+	for _, name := range []string{"webserver", "ziplist"} {
+		if _, err := parser.AddCommand(name, "", "", &ws); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if args := self_args(parser, []string{"-v", "-l", ":80"}, output); strings.Join(args, " ") != "--self webserver -v -l :80" {
+		t.Error("no command", args)
+	}
+	if args := self_args(parser, []string{"ziplist"}, output); strings.Join(args, " ") != "ziplist" {
+		t.Error("command", args)
+	}
+	if args := self_args(parser, nil, prepare_testzip(t)); len(args) != 0 {
+		t.Error("not embedded", args)
+	}
+	stdout, _ := runcmd_test(t, []string{"ziphttp", "-f", output, "config", "--embedded"}, 0)
+	if !strings.Contains(stdout, "listen: :8888") || !strings.Contains(stdout, "index: top.html") || strings.Contains(stdout, "hidden") {
+		t.Error("show embedded", stdout)
+	}
+	runcmd_test(t, []string{"ziphttp", "-f", prepare_testzip(t), "config", "--embedded"}, 1)
+}
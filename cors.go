@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORSRule is CORS policy of path prefix or glob
+type CORSRule struct {
+	Path        string   `json:"path"`
+	Origins     []string `json:"origins"`
+	Methods     []string `json:"methods,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	Expose      []string `json:"expose,omitempty"`
+	Credentials bool     `json:"credentials,omitempty"`
+	MaxAge      int      `json:"max-age,omitempty"`
+}
+
+func LoadCORSRules(filename string) ([]CORSRule, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var res []CORSRule
+	if err = json.NewDecoder(fp).Decode(&res); err != nil {
+		return nil, err
+	}
+	for i := range res {
+		if err = check_path_pattern(res[i].Path); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// allow_origin matches origin exactly, or by glob("https://*.example.com"). "*" matches any
+func (rule *CORSRule) allow_origin(origin string) bool {
+	for _, pat := range rule.Origins {
+		if pat == "*" || pat == origin {
+			return true
+		}
+		if matched, _ := path.Match(pat, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *CORSRule) wildcard() bool {
+	return slices.Contains(rule.Origins, "*") && !rule.Credentials
+}
+
+func (rule *CORSRule) methods() []string {
+	if len(rule.Methods) == 0 {
+		return []string{http.MethodGet, http.MethodHead}
+	}
+	return rule.Methods
+}
+
+// allow_headers returns false if some of requested headers are not allowed
+func (rule *CORSRule) allow_headers(requested []string) bool {
+	if slices.Contains(rule.Headers, "*") {
+		return true
+	}
+	for _, hdr := range requested {
+		if !slices.ContainsFunc(rule.Headers, func(v string) bool { return strings.EqualFold(v, hdr) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func split_list(values []string) []string {
+	res := make([]string, 0)
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				res = append(res, v)
+			}
+		}
+	}
+	return res
+}
+
+func (rule *CORSRule) set_origin(w http.ResponseWriter, origin string) {
+	if rule.wildcard() {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if rule.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// handle_cors sets CORS headers. returns true if preflight is responded
+func (h *ZipHandler) handle_cors(w http.ResponseWriter, r *http.Request, statuscode *int) bool {
+	var rule *CORSRule
+	urlpath := clean_path(r.URL.Path)
+	for i := range h.cors {
+		if match_path(h.cors[i].Path, urlpath) {
+			rule = &h.cors[i]
+			break
+		}
+	}
+	if rule == nil {
+		return false
+	}
+	if !rule.wildcard() {
+		w.Header().Add("Vary", "Origin")
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	preflight := r.Method == http.MethodOptions && method != ""
+	if preflight {
+		w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+	}
+	if !rule.allow_origin(origin) {
+		if preflight {
+			*statuscode = http.StatusForbidden
+			slog.Debug("cors origin denied", "origin", origin, "path", r.URL.Path)
+			w.WriteHeader(*statuscode)
+		}
+		return preflight
+	}
+	rule.set_origin(w, origin)
+	if !preflight {
+		if len(rule.Expose) != 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.Expose, ", "))
+		}
+		return false
+	}
+	requested := split_list(r.Header.Values("Access-Control-Request-Headers"))
+	if !slices.Contains(rule.methods(), method) || !rule.allow_headers(requested) {
+		*statuscode = http.StatusForbidden
+		slog.Debug("cors preflight denied", "origin", origin, "method", method, "headers", requested)
+		w.Header().Del("Access-Control-Allow-Origin")
+		w.Header().Del("Access-Control-Allow-Credentials")
+		w.WriteHeader(*statuscode)
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.methods(), ", "))
+	if len(requested) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if rule.MaxAge != 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAge))
+	}
+	*statuscode = http.StatusNoContent
+	w.WriteHeader(*statuscode)
+	return true
+}
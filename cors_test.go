@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		cors: []CORSRule{
+			{Path: "/4kb.txt", Origins: []string{"https://*.example.com"}, Methods: []string{"GET", "PUT"}, Headers: []string{"X-Token"}, Expose: []string{"ETag"}, Credentials: true, MaxAge: 600},
+			{Path: "/", Origins: []string{"*"}},
+		},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	for _, tc := range []struct {
+		method   string
+		path     string
+		headers  map[string]string
+		status   int
+		origin   string
+		vary     string
+		response map[string]string
+	}{
+		{"GET", "/4kb.txt", map[string]string{"Origin": "https://www.example.com"}, http.StatusOK, "https://www.example.com", "Origin",
+			map[string]string{"Access-Control-Allow-Credentials": "true", "Access-Control-Expose-Headers": "ETag"}},
+		{"GET", "/4kb.txt", map[string]string{"Origin": "https://evil.example.org"}, http.StatusOK, "", "Origin", nil},
+		{"GET", "/4kb.txt", map[string]string{}, http.StatusOK, "", "Origin", nil},
+		{"OPTIONS", "/4kb.txt", map[string]string{"Origin": "https://www.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "x-token"}, http.StatusNoContent, "https://www.example.com", "Origin",
+			map[string]string{"Access-Control-Allow-Methods": "GET, PUT", "Access-Control-Allow-Headers": "x-token", "Access-Control-Max-Age": "600"}},
+		{"OPTIONS", "/4kb.txt", map[string]string{"Origin": "https://www.example.com", "Access-Control-Request-Method": "DELETE"}, http.StatusForbidden, "", "Origin", nil},
+		{"OPTIONS", "/4kb.txt", map[string]string{"Origin": "https://www.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Other"}, http.StatusForbidden, "", "Origin", nil},
+		{"OPTIONS", "/4kb.txt", map[string]string{"Origin": "https://evil.example.org", "Access-Control-Request-Method": "GET"}, http.StatusForbidden, "", "Origin", nil},
+		{"GET", "/512b.txt", map[string]string{"Origin": "https://any.example.org"}, http.StatusOK, "*", "", nil},
+		{"OPTIONS", "/512b.txt", map[string]string{"Origin": "https://any.example.org", "Access-Control-Request-Method": "HEAD"}, http.StatusNoContent, "*", "Access-Control-Request-Method, Access-Control-Request-Headers", map[string]string{"Access-Control-Allow-Methods": "GET, HEAD"}},
+	} {
+		req := httptest.NewRequest(tc.method, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		hdl.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Error("status", tc.method, tc.path, tc.headers, w.Code)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != tc.origin {
+			t.Error("origin", tc.method, tc.path, tc.headers, origin)
+		}
+		if vary := w.Header().Get("Vary"); vary != tc.vary {
+			t.Error("vary", tc.method, tc.path, tc.headers, vary)
+		}
+		for k, v := range tc.response {
+			if w.Header().Get(k) != v {
+				t.Error("header", k, w.Header().Get(k))
+			}
+		}
+		if tc.method == http.MethodOptions && w.Body.Len() != 0 {
+			t.Error("preflight has body", w.Body.Len())
+		}
+	}
+}
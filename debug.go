@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DebugHeader = "X-Ziphttp-Debug"
+
+type DebugOption struct {
+	Networks []*net.IPNet
+	Secret   string
+}
+
+func parse_networks(cidrs []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, v := range cidrs {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+func contains_ip(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remote_ip returns IP of peer
+func remote_ip(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func (opt *DebugOption) allowed(r *http.Request) bool {
+	if opt.Secret != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(DebugHeader)), []byte(opt.Secret)) == 1 {
+			return true
+		}
+	}
+	if ip := remote_ip(r); ip != nil && contains_ip(opt.Networks, ip) {
+		return true
+	}
+	return false
+}
+
+type debugKey struct{}
+
+// debugWriter adds Server-Timing to response. copy duration is sent as trailer if chunked
+type debugWriter struct {
+	http.ResponseWriter
+	start   time.Time
+	written time.Time
+	trailer bool
+}
+
+func newDebugWriter(w http.ResponseWriter, r *http.Request) *debugWriter {
+	return &debugWriter{ResponseWriter: w, start: time.Now(), trailer: r.Method != http.MethodHead && r.ProtoAtLeast(1, 1)}
+}
+
+func (w *debugWriter) WriteHeader(statusCode int) {
+	if statusCode >= 200 && w.written.IsZero() {
+		w.written = time.Now()
+		w.Header().Add("Server-Timing", fmt.Sprintf("lookup;dur=%.3f", float64(w.written.Sub(w.start).Microseconds())/1000))
+		w.trailer = w.trailer && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+		if w.trailer {
+			// trailer needs chunked encoding in HTTP/1.1
+			w.Header().Del("Content-Length")
+			w.Header().Set("Trailer", "Server-Timing")
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *debugWriter) Write(b []byte) (int, error) {
+	if w.written.IsZero() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps sendfile of underlying writer
+func (w *debugWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.written.IsZero() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return io.Copy(w.ResponseWriter, src)
+}
+
+func (w *debugWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends copy duration as trailer and logs it
+func (w *debugWriter) finish(r *http.Request) {
+	if w.written.IsZero() {
+		return
+	}
+	lookup, copied := w.written.Sub(w.start), time.Since(w.written)
+	if w.trailer {
+		// header is already sent. value of declared trailer is replaced
+		w.Header().Set("Server-Timing", fmt.Sprintf("copy;dur=%.3f", float64(copied.Microseconds())/1000))
+	}
+	slog.Info("server timing", "method", r.Method, "url", r.URL, "lookup", lookup, "copy", copied)
+}
+
+func body_mode(fi *zip.File, encoding string) string {
+	switch {
+	case encoding == "" && fi.Method == zip.Store:
+		return "raw"
+	case encoding == "":
+		return "decompressed"
+	case encoding == "gzip" && fi.Method == zip.Deflate:
+		return "gzip-wrapped"
+	case fi.Method == zip.Store && encoding != "dcb" && encoding != "dcz":
+		return "dynamic"
+	}
+	return "raw"
+}
+
+// debug_source adds X-Ziphttp-Source if debug is enabled for the request
+func (h *ZipHandler) debug_source(w http.ResponseWriter, r *http.Request, idx int, fi *zip.File, encoding string) {
+	if r.Context().Value(debugKey{}) == nil {
+		return
+	}
+	archive := ""
+	zf, localidx := h.getzip(idx)
+	if named, ok := zf.(namedZipFile); ok {
+		archive = named.Name()
+	}
+	source := fmt.Sprintf("archive=%q; index=%d; method=%d; body=%s", archive, localidx, fi.Method, body_mode(fi, encoding))
+	slog.Debug("source", "name", fi.Name, "source", source)
+	w.Header().Set("X-Ziphttp-Source", source)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	t.Parallel()
+	networks, err := parse_networks([]string{"10.0.0.0/8", "192.0.2.1", "::1"})
+	if err != nil {
+		t.Error("parse", err)
+		return
+	}
+	for ip, expected := range map[string]bool{
+		"10.1.2.3":  true,
+		"192.0.2.1": true,
+		"192.0.2.2": false,
+		"::1":       true,
+		"::2":       false,
+	} {
+		if res := contains_ip(networks, net.ParseIP(ip)); res != expected {
+			t.Error("contains", ip, res)
+		}
+	}
+	if _, err := parse_networks([]string{"invalid"}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestDebugHeaders(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		debug:     &DebugOption{Networks: []*net.IPNet{}, Secret: "s3cret"},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	for _, tc := range []struct {
+		path     string
+		encoding string
+		secret   string
+		body     string
+	}{
+		{"/4kb.txt", "gzip", "s3cret", "body=gzip-wrapped"},
+		{"/4kb.txt", "", "s3cret", "body=decompressed"},
+		{"/4kb.txt", "deflate", "s3cret", "body=raw"},
+		{"/512b.txt", "", "s3cret", "body=raw"},
+		{"/4kb.txt", "gzip", "wrong", ""},
+		{"/4kb.txt", "gzip", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", tc.encoding)
+		if tc.secret != "" {
+			req.Header.Set(DebugHeader, tc.secret)
+		}
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != http.StatusOK {
+			t.Error("status", got.Code)
+		}
+		source := got.Result().Header.Get("X-Ziphttp-Source")
+		timing := got.Result().Header.Get("Server-Timing")
+		if tc.body == "" {
+			if source != "" || timing != "" {
+				t.Error("not allowed", tc.secret, source, timing)
+			}
+			continue
+		}
+		if !strings.HasPrefix(source, `archive="test.zip"; index=`) || !strings.HasSuffix(source, tc.body) {
+			t.Error("source", tc.path, tc.encoding, source)
+		}
+		if !strings.HasPrefix(timing, "lookup;dur=") {
+			t.Error("timing", timing)
+		}
+		if trailer := got.Result().Trailer.Get("Server-Timing"); !strings.HasPrefix(trailer, "copy;dur=") {
+			t.Error("trailer", trailer)
+		}
+	}
+}
+
+func TestDebugTrailer(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		debug:     &DebugOption{Networks: []*net.IPNet{}, Secret: "s3cret"},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	srv := httptest.NewServer(&hdl)
+	defer srv.Close()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/512b.txt", nil)
+	if err != nil {
+		t.Error("request", err)
+		return
+	}
+	req.Header.Set(DebugHeader, "s3cret")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error("get", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || len(body) != 512 {
+		t.Error("body", len(body), err)
+	}
+	if timing := resp.Header.Get("Server-Timing"); !strings.HasPrefix(timing, "lookup;dur=") {
+		t.Error("timing", timing)
+	}
+	if trailer := resp.Trailer.Get("Server-Timing"); !strings.HasPrefix(trailer, "copy;dur=") {
+		t.Error("trailer", resp.Trailer)
+	}
+}
+
+func TestDebugAllowedByIP(t *testing.T) {
+	t.Parallel()
+	networks, _ := parse_networks([]string{"192.0.2.0/24"})
+	opt := DebugOption{Networks: networks}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", nil)
+	if !opt.allowed(req) {
+		t.Error("not allowed", req.RemoteAddr)
+	}
+	req.RemoteAddr = "198.51.100.1:1234"
+	if opt.allowed(req) {
+		t.Error("allowed", req.RemoteAddr)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// RFC 9842 stream headers. followed by SHA-256 of dictionary
+var (
+	dcbMagic = []byte{0xff, 0x44, 0x43, 0x42}
+	dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+)
+
+var ErrInvalidDictionaryStream = errors.New("invalid dictionary compressed stream")
+
+type dictVariant struct {
+	idx      int
+	hash     string
+	encoding string
+}
+
+// dictionary_hash reads header of dcb/dcz entry and returns base64 encoded hash of dictionary
+func dictionary_hash(fi *zip.File, encoding string) (string, error) {
+	magic := dczMagic
+	if encoding == "dcb" {
+		magic = dcbMagic
+	}
+	rd, err := fi.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
+	header := make([]byte, len(magic)+sha256.Size)
+	if _, err = io.ReadFull(rd, header); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return "", ErrInvalidDictionaryStream
+	}
+	return base64.StdEncoding.EncodeToString(header[len(magic):]), nil
+}
+
+// dictionary_base returns name of original entry. app.js.dcz and app.js.<id>.dcz are variants of app.js
+func dictionary_base(name string, methodmap map[string]map[uint16]int) (string, string) {
+	ext := path.Ext(name)
+	if ext != ".dcb" && ext != ".dcz" {
+		return "", ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	if _, ok := methodmap[base]; !ok {
+		base = strings.TrimSuffix(base, path.Ext(base))
+	}
+	if _, ok := methodmap[base]; !ok {
+		return "", ""
+	}
+	return base, ext[1:]
+}
+
+// dictionary_variants moves dcb/dcz entries from methodmap. caller must not hold lock
+func dictionary_variants(methodmap map[string]map[uint16]int, ctypes map[int]string, inputs []ZipFile) map[string][]dictVariant {
+	res := make(map[string][]dictVariant)
+	for name, bymethod := range methodmap {
+		base, encoding := dictionary_base(name, methodmap)
+		if base == "" {
+			continue
+		}
+		idx, ok := bymethod[zip.Store]
+		if !ok {
+			slog.Warn("dictionary variant should be stored", "name", name)
+			continue
+		}
+		hash, err := dictionary_hash(file_of(inputs, idx), encoding)
+		if err != nil {
+			slog.Warn("dictionary variant", "name", name, "error", err)
+			continue
+		}
+		for _, baseidx := range methodmap[base] {
+			fi := file_of(inputs, baseidx)
+			ctype, ok := ctypes[baseidx]
+			if !ok {
+				ctype = make_contenttype(fi.Comment)
+			}
+			if ctype == "" {
+				ctype = make_contentbyext(fi.Name)
+			}
+			ctypes[idx] = ctype
+			break
+		}
+		slog.Debug("dictionary variant", "name", base, "variant", name, "hash", hash)
+		res[base] = append(res[base], dictVariant{idx: idx, hash: hash, encoding: encoding})
+	}
+	for _, variants := range res {
+		for _, v := range variants {
+			delete(methodmap, file_of(inputs, v.idx).Name)
+		}
+	}
+	return res
+}
+
+// send_dictionary serves delta compressed variant if client has the dictionary
+func (h *ZipHandler) send_dictionary(w http.ResponseWriter, r *http.Request, encodings Encoding, fname string, statuscode *int) bool {
+	variants, ok := h.dictvariants[fname]
+	if !ok {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding, Available-Dictionary")
+	available := strings.Trim(strings.TrimSpace(r.Header.Get("Available-Dictionary")), ":")
+	if available == "" {
+		return false
+	}
+	for _, v := range variants {
+		if v.hash != available {
+			continue
+		}
+		if (v.encoding == "dcz" && encodings&EncodingDcz == 0) || (v.encoding == "dcb" && encodings&EncodingDcb == 0) {
+			continue
+		}
+		fi := h.getidx(v.idx)
+		if fi == nil {
+			continue
+		}
+		slog.Debug("dictionary compressed", "name", fname, "encoding", v.encoding, "size", fi.UncompressedSize64)
+		if err := h.write_header(w, r, fname, v.idx, fi, v.encoding, fi.UncompressedSize64, statuscode); err != nil {
+			return err == ErrNotModified
+		}
+		rd, err := h.openraw(v.idx, fi)
+		if err != nil {
+			slog.Error("OpenRaw", "name", fi.Name, "error", err)
+			return false
+		}
+		defer rd.Close()
+		*statuscode = http.StatusOK
+		w.WriteHeader(*statuscode)
+		if written, err := io.Copy(w, raw_source(rd)); err != nil {
+			slog.Error("copy", "written", written, "error", err)
+		} else {
+			slog.Debug("written", "written", written)
+		}
+		return true
+	}
+	return false
+}
+
+type dictionary struct {
+	name string
+	data []byte
+	hash []byte
+}
+
+func load_dictionary(filename string) (*dictionary, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return &dictionary{name: filename, data: data, hash: hash[:]}, nil
+}
+
+// write_dictionary_variants adds <name>.<hash>.dcz entries compressed with dictionaries.
+// dcb is not built: andybalholm/brotli keeps dictionary distances in its distance cache, which raw dictionary decoders do not
+func (cmd *ZipCmd) write_dictionary_variants(wr *zip.Writer, files []*zip.File) error {
+	dicts := make([]*dictionary, 0, len(cmd.Dictionary))
+	for _, fn := range cmd.Dictionary {
+		dict, err := load_dictionary(fn)
+		if err != nil {
+			slog.Error("load dictionary", "name", fn, "error", err)
+			return err
+		}
+		dicts = append(dicts, dict)
+	}
+	for _, f := range files {
+		if len(cmd.DictPattern) != 0 && !ismatch(f.Name, cmd.DictPattern) {
+			continue
+		}
+		if f.UncompressedSize64 < uint64(cmd.MinSize) {
+			continue
+		}
+		rd, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return err
+		}
+		for _, dict := range dicts {
+			compressed, err := dcz_compress(dict.data, data, cmd.Level)
+			if err != nil {
+				slog.Error("dictionary compress", "name", f.Name, "dictionary", dict.name, "error", err)
+				return err
+			}
+			if uint64(len(dczMagic)+len(dict.hash)+len(compressed)) >= f.CompressedSize64 {
+				slog.Debug("dictionary not effective", "name", f.Name, "dictionary", dict.name, "size", len(compressed), "compressed", f.CompressedSize64)
+				continue
+			}
+			fh := zip.FileHeader{
+				Name:     f.Name + "." + hex.EncodeToString(dict.hash)[:16] + ".dcz",
+				Method:   zip.Store,
+				Modified: f.Modified,
+			}
+			ofp, err := wr.CreateHeader(&fh)
+			if err != nil {
+				return err
+			}
+			for _, b := range [][]byte{dczMagic, dict.hash, compressed} {
+				if _, err = ofp.Write(b); err != nil {
+					return err
+				}
+			}
+			slog.Info("dictionary compressed", "name", fh.Name, "dictionary", dict.name, "size", len(compressed), "compressed", f.CompressedSize64)
+		}
+	}
+	return wr.Flush()
+}
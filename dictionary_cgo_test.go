@@ -0,0 +1,97 @@
+//go:build cgo
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/jessevdk/go-flags"
+)
+
+func TestZipCmdDictionary(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	base := t.TempDir()
+	srcdir := filepath.Join(base, "src")
+	if err := os.Mkdir(srcdir, 0o755); err != nil {
+		t.Error("mkdir", err)
+		return
+	}
+	var olddata, newdata strings.Builder
+	for i := range 2000 {
+		line := "function f" + strings.Repeat("x", i%37) + "() { return " + strings.Repeat("y", i%53) + "; }\n"
+		olddata.WriteString(line)
+		if i == 1000 {
+			newdata.WriteString("// new feature\n")
+		}
+		newdata.WriteString(line)
+	}
+	dict := filepath.Join(base, "app.old.js")
+	if err := os.WriteFile(dict, []byte(olddata.String()), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(srcdir, "app.js"), []byte(newdata.String()), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	output := filepath.Join(base, "output.zip")
+	zz := ZipCmd{
+		StripRoot:  true,
+		MinSize:    512,
+		Method:     "deflate",
+		Dictionary: []string{dict},
+	}
+	globalOption.Archive = flags.Filename(output)
+	if err := zz.Execute([]string{srcdir}); err != nil {
+		t.Error("failed", err)
+		return
+	}
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 {
+		t.Error("files", len(zr.File))
+		return
+	}
+	fi := zr.File[1]
+	if !strings.HasPrefix(fi.Name, "app.js.") || !strings.HasSuffix(fi.Name, ".dcz") || fi.Method != zip.Store {
+		t.Error("variant", fi.Name, fi.Method)
+	}
+	if fi.CompressedSize64 >= zr.File[0].CompressedSize64 {
+		t.Error("not effective", fi.CompressedSize64, zr.File[0].CompressedSize64)
+	}
+	rd, err := fi.Open()
+	if err != nil {
+		t.Error("open variant", err)
+		return
+	}
+	defer rd.Close()
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	hash := sha256.Sum256([]byte(olddata.String()))
+	if !bytes.HasPrefix(data, append(append([]byte{}, dczMagic...), hash[:]...)) {
+		t.Error("header", data[:40])
+	}
+	zrd := zstd.NewReaderDict(bytes.NewReader(data[40:]), []byte(olddata.String()))
+	decoded, err := io.ReadAll(zrd)
+	if err != nil || string(decoded) != newdata.String() {
+		t.Error("decode", len(decoded), err)
+	}
+}
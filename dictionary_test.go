@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDictionaryBase(t *testing.T) {
+	t.Parallel()
+	methodmap := map[string]map[uint16]int{"app.js": {}, "app.js.dcz": {}, "app.js.0123.dcb": {}}
+	for name, expected := range map[string][2]string{
+		"app.js.dcz":      {"app.js", "dcz"},
+		"app.js.0123.dcb": {"app.js", "dcb"},
+		"app.js":          {"", ""},
+		"other.js.dcz":    {"", ""},
+	} {
+		base, encoding := dictionary_base(name, methodmap)
+		if base != expected[0] || encoding != expected[1] {
+			t.Error("base", name, base, encoding)
+		}
+	}
+}
+
+func TestDictionaryServe(t *testing.T) {
+	t.Parallel()
+	hash := sha256.Sum256([]byte("old version"))
+	payload := append(append(append([]byte{}, dcbMagic...), hash[:]...), []byte("delta")...)
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	for _, ent := range []struct {
+		name string
+		data []byte
+		meta *ZipMeta
+	}{
+		{"app.js", bytes.Repeat([]byte("console.log(1);\n"), 100), &ZipMeta{UseAsDictionary: "/app*.js", CacheControl: "max-age=60", Headers: map[string]string{"X-Test": "hello"}}},
+		{"app.js.dcb", payload, nil},
+		{"broken.js", []byte("broken"), nil},
+		{"broken.js.dcz", []byte("not a dcz stream"), nil},
+	} {
+		fh := zip.FileHeader{Name: ent.name, Method: zip.Store}
+		if ent.meta != nil {
+			if err := SetZipMeta(&fh, ent.meta); err != nil {
+				t.Error("meta", err)
+			}
+		}
+		ofp, err := wr.CreateHeader(&fh)
+		if err != nil {
+			t.Error("create", err)
+			return
+		}
+		if _, err = ofp.Write(ent.data); err != nil {
+			t.Error("write", err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Error("close", err)
+	}
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+	}
+	if err := hdl.initialize_memory([][]byte{buf.Bytes()}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	if hdl.exists("app.js.dcb") {
+		t.Error("variant is visible")
+	}
+	if !hdl.exists("broken.js.dcz") {
+		t.Error("invalid variant is hidden")
+	}
+	available := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+	other := sha256.Sum256([]byte("other"))
+	for _, tc := range []struct {
+		accept    string
+		available string
+		encoding  string
+	}{
+		{"gzip, br, dcb", available, "dcb"},
+		{"gzip, br, dcz", available, ""},
+		{"gzip, br, dcb", ":" + base64.StdEncoding.EncodeToString(other[:]) + ":", ""},
+		{"gzip, br, dcb", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/app.js", bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", tc.accept)
+		if tc.available != "" {
+			req.Header.Set("Available-Dictionary", tc.available)
+		}
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != http.StatusOK {
+			t.Error("status", got.Code)
+		}
+		if enc := got.Result().Header.Get("Content-Encoding"); enc != tc.encoding {
+			t.Error("content-encoding", tc.accept, tc.available, enc)
+		}
+		if vary := got.Result().Header.Get("Vary"); vary != "Accept-Encoding, Available-Dictionary" {
+			t.Error("vary", vary)
+		}
+		if ctype := got.Result().Header.Get("Content-Type"); ctype != "text/javascript; charset=utf-8" {
+			t.Error("content-type", ctype)
+		}
+		if tc.encoding != "" && !bytes.Equal(got.Body.Bytes(), payload) {
+			t.Error("body", got.Body.Len())
+		}
+		// metadata of base entry is applied to variant
+		if got.Result().Header.Get("Use-As-Dictionary") != `match="/app*.js"` {
+			t.Error("use-as-dictionary", tc.encoding, got.Result().Header.Get("Use-As-Dictionary"))
+		}
+		if got.Result().Header.Get("Cache-Control") != "max-age=60" || got.Result().Header.Get("X-Test") != "hello" {
+			t.Error("metadata", tc.encoding, got.Result().Header)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// file_digest returns hex encoded SHA-256 of uncompressed content
+func file_digest(fi *zip.File) (string, error) {
+	rd, err := fi.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rd.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, rd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// set_digest stores SHA-256 of f to meta
+func set_digest(f *zip.File, meta *ZipMeta) error {
+	digest, err := file_digest(f)
+	if err != nil {
+		slog.Error("digest", "name", f.Name, "error", err)
+		return err
+	}
+	meta.Digest = digest
+	return nil
+}
+
+// digestCall is in-flight computation shared by concurrent requests
+type digestCall struct {
+	done   chan struct{}
+	digest string
+}
+
+// DigestCache keeps SHA-256 of entries by name
+type DigestCache struct {
+	lock     sync.RWMutex
+	lazy     bool
+	digests  map[string]string
+	inflight map[string]*digestCall
+}
+
+func NewDigestCache(digests map[string]string, lazy bool) *DigestCache {
+	return &DigestCache{digests: digests, lazy: lazy, inflight: make(map[string]*digestCall)}
+}
+
+// Get returns digest of fi, computes it once if lazy
+func (c *DigestCache) Get(fi *zip.File) string {
+	c.lock.RLock()
+	digest, ok := c.digests[fi.Name]
+	c.lock.RUnlock()
+	if ok || !c.lazy {
+		return digest
+	}
+	c.lock.Lock()
+	if digest, ok = c.digests[fi.Name]; ok {
+		c.lock.Unlock()
+		return digest
+	}
+	if call, ok := c.inflight[fi.Name]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return call.digest
+	}
+	call := &digestCall{done: make(chan struct{})}
+	c.inflight[fi.Name] = call
+	c.lock.Unlock()
+	digest, err := file_digest(fi)
+	if err != nil {
+		slog.Error("digest", "name", fi.Name, "error", err)
+	} else {
+		slog.Debug("digest computed", "name", fi.Name, "digest", digest)
+	}
+	c.lock.Lock()
+	if err == nil {
+		c.digests[fi.Name] = digest
+	}
+	delete(c.inflight, fi.Name)
+	c.lock.Unlock()
+	call.digest = digest
+	close(call.done)
+	return digest
+}
+
+// parse_want parses Want-Repr-Digest and returns preference of sha-256. -1 if not exists
+func parse_want(header string) int {
+	if header == "" {
+		return -1
+	}
+	for _, v := range strings.Split(header, ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(v), "=")
+		if strings.TrimSpace(k) != "sha-256" {
+			continue
+		}
+		if pref, err := strconv.Atoi(strings.TrimSpace(val)); err == nil {
+			return pref
+		}
+		return 1
+	}
+	return 0
+}
+
+// etag makes strong ETag from digest, or weak one from CRC32
+func (h *ZipHandler) etag(fi *zip.File, encoding string) string {
+	digest := ""
+	if h.digests != nil {
+		digest = h.digests.Get(fi)
+	}
+	if len(digest) < 32 {
+		if encoding == "" {
+			return "W/" + strconv.FormatUint(uint64(fi.CRC32), 16)
+		}
+		return "W/" + strconv.FormatUint(uint64(fi.CRC32), 16) + "_" + encoding
+	}
+	if encoding == "" {
+		return `"` + digest[:32] + `"`
+	}
+	return `"` + digest[:32] + "-" + encoding + `"`
+}
+
+// write_digest sets Repr-Digest and Content-Digest of identity response
+func (h *ZipHandler) write_digest(w http.ResponseWriter, r *http.Request, fi *zip.File) {
+	if h.digests == nil {
+		return
+	}
+	want_repr := parse_want(r.Header.Get("Want-Repr-Digest"))
+	want_content := parse_want(r.Header.Get("Want-Content-Digest"))
+	if want_repr == 0 && want_content == 0 {
+		return
+	}
+	digest := h.digests.Get(fi)
+	if digest == "" {
+		return
+	}
+	raw, err := hex.DecodeString(digest)
+	if err != nil {
+		slog.Warn("invalid digest", "name", fi.Name, "digest", digest)
+		return
+	}
+	value := "sha-256=:" + base64.StdEncoding.EncodeToString(raw) + ":"
+	if want_repr != 0 {
+		w.Header().Set("Repr-Digest", value)
+	}
+	if want_content != 0 {
+		w.Header().Set("Content-Digest", value)
+	}
+}
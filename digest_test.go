@@ -0,0 +1,215 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestParseWant(t *testing.T) {
+	t.Parallel()
+	for header, expected := range map[string]int{
+		"":                      -1,
+		"sha-256=3":             3,
+		"sha-512=3, sha-256=0":  0,
+		"sha-512=3":             0,
+		"sha-256=10, sha-512=1": 10,
+	} {
+		if res := parse_want(header); res != expected {
+			t.Error("want", header, res, expected)
+		}
+	}
+}
+
+func TestStrongETag(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname:  "index.html",
+		methodmap:  make(map[string]map[uint16]int),
+		lazydigest: true,
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	fi := hdl.getidx(hdl.methodmap["4kb.txt"][zip.Deflate])
+	expected, err := file_digest(fi)
+	if err != nil {
+		t.Error("digest", err)
+		return
+	}
+	raw, _ := hex.DecodeString(expected)
+	reprdigest := "sha-256=:" + base64.StdEncoding.EncodeToString(raw) + ":"
+	etags := make(map[string]bool)
+	for _, tc := range []struct {
+		encoding string
+		want     string
+		digest   string
+	}{
+		{"", "", reprdigest},
+		{"", "sha-256=0", ""},
+		{"", "sha-512=1, sha-256=5", reprdigest},
+		{"gzip", "", ""},
+		{"deflate", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt", bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", tc.encoding)
+		if tc.want != "" {
+			req.Header.Set("Want-Repr-Digest", tc.want)
+		}
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		etag := got.Result().Header.Get("Etag")
+		if !strings.HasPrefix(etag, `"`+expected[:32]) {
+			t.Error("etag", tc.encoding, etag)
+		}
+		etags[etag] = true
+		if digest := got.Result().Header.Get("Repr-Digest"); digest != tc.digest {
+			t.Error("repr-digest", tc.encoding, tc.want, digest)
+		}
+		if tc.digest != "" {
+			sum := sha256.Sum256(got.Body.Bytes())
+			if hex.EncodeToString(sum[:]) != expected {
+				t.Error("body digest")
+			}
+		}
+		// conditional
+		req.Header.Set("If-None-Match", etag)
+		got = httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != http.StatusNotModified {
+			t.Error("not modified", tc.encoding, got.Code)
+		}
+	}
+	if len(etags) != 3 {
+		t.Error("etag per encoding", etags)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt.gz", bytes.NewBuffer([]byte{}))
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if etag := got.Result().Header.Get("Etag"); etag != `"`+expected[:32]+`-gz"` {
+		t.Error("etag of gz", etag)
+	}
+}
+
+func TestZipCmdDigest(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	base := t.TempDir()
+	content := strings.Repeat("hello world\n", 100)
+	if err := os.WriteFile(filepath.Join(base, "hello.txt"), []byte(content), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	output := filepath.Join(t.TempDir(), "output.zip")
+	zz := ZipCmd{
+		StripRoot: true,
+		MinSize:   512,
+		Method:    "deflate",
+		Digest:    true,
+		Sidecar:   true,
+	}
+	globalOption.Archive = flags.Filename(output)
+	if err := zz.Execute([]string{base}); err != nil {
+		t.Error("failed", err)
+		return
+	}
+	sum := sha256.Sum256([]byte(content))
+	expected := hex.EncodeToString(sum[:])
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer zr.Close()
+	meta, err := ParseZipMeta(zr.File[0].Extra)
+	if err != nil || meta == nil || meta.Digest != expected {
+		t.Error("meta", meta, err)
+	}
+	idx, err := LoadZipIndex(output)
+	if err != nil {
+		t.Error("sidecar", err)
+		return
+	}
+	if idx.Entries[0].Digest != expected {
+		t.Error("sidecar digest", idx.Entries[0].Digest)
+	}
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+	}
+	if err := hdl.initialize_file([]string{output}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	defer hdl.Close()
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/hello.txt", bytes.NewBuffer([]byte{}))
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if etag := got.Result().Header.Get("Etag"); etag != `"`+expected[:32]+`"` {
+		t.Error("etag", etag)
+	}
+}
+
+func TestDigestCacheOnce(t *testing.T) {
+	t.Parallel()
+	zr, err := zip.NewReader(bytes.NewReader(testzip), int64(len(testzip)))
+	if err != nil {
+		t.Error("zip", err)
+		return
+	}
+	fi := zr.File[slices.IndexFunc(zr.File, func(f *zip.File) bool { return f.Method == zip.Deflate })]
+	expected, err := file_digest(fi)
+	if err != nil {
+		t.Error("digest", err)
+		return
+	}
+	var calls atomic.Int32
+	wait := make(chan struct{})
+	zr.RegisterDecompressor(zip.Deflate, func(r io.Reader) io.ReadCloser {
+		calls.Add(1)
+		<-wait
+		return flate.NewReader(r)
+	})
+	cache := NewDigestCache(make(map[string]string), true)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if digest := cache.Get(fi); digest != expected {
+				t.Error("digest", digest)
+			}
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Error("computed", calls.Load())
+	}
+	if len(cache.inflight) != 0 {
+		t.Error("inflight", len(cache.inflight))
+	}
+}
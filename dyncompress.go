@@ -0,0 +1,215 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// compressors for on-the-fly encoding. brotli/zstd are registered in their init()
+var dynamic_compressors = map[string]func(io.Writer) (io.WriteCloser, error){
+	"gzip": func(out io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(out), nil
+	},
+}
+
+type dynamicKey struct {
+	crc32    uint32
+	size     uint64
+	encoding string
+}
+
+type dynamicEntry struct {
+	key  dynamicKey
+	data []byte
+}
+
+// DynamicCache is LRU cache of compressed data bounded by total size
+type DynamicCache struct {
+	lock  sync.Mutex
+	limit int64
+	used  int64
+	lru   *list.List
+	items map[dynamicKey]*list.Element
+}
+
+func NewDynamicCache(limit int64) *DynamicCache {
+	return &DynamicCache{
+		limit: limit,
+		lru:   list.New(),
+		items: make(map[dynamicKey]*list.Element),
+	}
+}
+
+func (c *DynamicCache) Get(key dynamicKey) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*dynamicEntry).data, true
+	}
+	return nil, false
+}
+
+func (c *DynamicCache) Add(key dynamicKey, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if int64(len(data)) > c.limit {
+		return
+	}
+	if elem, ok := c.items[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&dynamicEntry{key: key, data: data})
+	c.used += int64(len(data))
+	for c.used > c.limit {
+		last := c.lru.Back()
+		ent := last.Value.(*dynamicEntry)
+		slog.Debug("evict", "crc32", ent.key.crc32, "encoding", ent.key.encoding, "size", len(ent.data))
+		c.lru.Remove(last)
+		delete(c.items, ent.key)
+		c.used -= int64(len(ent.data))
+	}
+}
+
+// dynamicCall is in-flight compression shared by concurrent requests
+type dynamicCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+type DynamicCompress struct {
+	cache    *DynamicCache
+	types    []string
+	minsize  uint64
+	maxsize  uint64
+	lock     sync.Mutex
+	inflight map[dynamicKey]*dynamicCall
+}
+
+func (d *DynamicCompress) compressible(ctype string, size uint64) bool {
+	if size < d.minsize || size > d.maxsize {
+		return false
+	}
+	mtype := strings.TrimSpace(strings.SplitN(ctype, ";", 2)[0])
+	return ismatch0(mtype, d.types)
+}
+
+// compress returns compressed data from cache, or waits for same compression in progress
+func (d *DynamicCompress) compress(fi *zip.File, encoding string) ([]byte, error) {
+	key := dynamicKey{crc32: fi.CRC32, size: fi.UncompressedSize64, encoding: encoding}
+	if data, ok := d.cache.Get(key); ok {
+		slog.Debug("dynamic cache hit", "name", fi.Name, "encoding", encoding)
+		return data, nil
+	}
+	d.lock.Lock()
+	if call, ok := d.inflight[key]; ok {
+		d.lock.Unlock()
+		slog.Debug("dynamic compress wait", "name", fi.Name, "encoding", encoding)
+		<-call.done
+		return call.data, call.err
+	}
+	if d.inflight == nil {
+		d.inflight = make(map[dynamicKey]*dynamicCall)
+	}
+	call := &dynamicCall{done: make(chan struct{})}
+	d.inflight[key] = call
+	d.lock.Unlock()
+	call.data, call.err = d.compress1(fi, encoding)
+	if call.err == nil {
+		d.cache.Add(key, call.data)
+	}
+	d.lock.Lock()
+	delete(d.inflight, key)
+	d.lock.Unlock()
+	close(call.done)
+	return call.data, call.err
+}
+
+func (d *DynamicCompress) compress1(fi *zip.File, encoding string) ([]byte, error) {
+	rd, err := fi.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	buf := bytes.Buffer{}
+	wr, err := dynamic_compressors[encoding](&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(wr, rd); err != nil {
+		return nil, err
+	}
+	if err = wr.Close(); err != nil {
+		return nil, err
+	}
+	slog.Debug("dynamic compressed", "name", fi.Name, "encoding", encoding, "original", fi.UncompressedSize64, "compressed", buf.Len())
+	return buf.Bytes(), nil
+}
+
+func choose_dynamic(encodings Encoding) string {
+	for _, enc := range []struct {
+		flag Encoding
+		name string
+	}{
+		{EncodingBrotli, "br"},
+		{EncodingZstd, "zstd"},
+		{EncodingGzip, "gzip"},
+	} {
+		if encodings&enc.flag == 0 {
+			continue
+		}
+		if _, ok := dynamic_compressors[enc.name]; ok {
+			return enc.name
+		}
+	}
+	return ""
+}
+
+// send_dynamic compresses stored entry on-the-fly
+func (h *ZipHandler) send_dynamic(w http.ResponseWriter, r *http.Request, encodings Encoding, filebyenc map[uint16]int, fname string, statuscode *int) bool {
+	idx, ok := filebyenc[zip.Store]
+	if !ok {
+		return false
+	}
+	encoding := choose_dynamic(encodings)
+	if encoding == "" {
+		return false
+	}
+	fi := h.getidx(idx)
+	if fi == nil {
+		return false
+	}
+	if !h.dynamic.compressible(h.contenttype(idx, fi), fi.UncompressedSize64) {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if conditional(r, h.etag(fi, encoding), fi) {
+		// 304 has no body to compress
+		return h.write_header(w, r, fi.Name, idx, fi, encoding, 0, statuscode) == ErrNotModified
+	}
+	data, err := h.dynamic.compress(fi, encoding)
+	if err != nil {
+		slog.Error("dynamic compress", "name", fname, "encoding", encoding, "error", err)
+		return false
+	}
+	if err = h.write_header(w, r, fi.Name, idx, fi, encoding, uint64(len(data)), statuscode); err != nil {
+		return err == ErrNotModified
+	}
+	*statuscode = http.StatusOK
+	w.WriteHeader(*statuscode)
+	if written, err := w.Write(data); err != nil {
+		slog.Error("write", "written", written, "error", err)
+	} else {
+		slog.Debug("written", "written", written)
+	}
+	return true
+}
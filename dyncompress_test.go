@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDynamicCacheEvict(t *testing.T) {
+	t.Parallel()
+	c := NewDynamicCache(10)
+	c.Add(dynamicKey{crc32: 1, encoding: "gzip"}, []byte("12345"))
+	c.Add(dynamicKey{crc32: 2, encoding: "gzip"}, []byte("12345"))
+	if _, ok := c.Get(dynamicKey{crc32: 1, encoding: "gzip"}); !ok {
+		t.Error("missing 1")
+	}
+	// 2 is least recently used
+	c.Add(dynamicKey{crc32: 3, encoding: "gzip"}, []byte("123"))
+	if _, ok := c.Get(dynamicKey{crc32: 2, encoding: "gzip"}); ok {
+		t.Error("not evicted 2")
+	}
+	if _, ok := c.Get(dynamicKey{crc32: 1, encoding: "br"}); ok {
+		t.Error("encoding mismatch")
+	}
+	c.Add(dynamicKey{crc32: 4, encoding: "gzip"}, []byte("too large data"))
+	if _, ok := c.Get(dynamicKey{crc32: 4, encoding: "gzip"}); ok {
+		t.Error("too large")
+	}
+	if c.used != 8 {
+		t.Error("used", c.used)
+	}
+}
+
+func TestDynamicCompress(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		dynamic: &DynamicCompress{
+			cache:   NewDynamicCache(1 << 20),
+			types:   []string{"text/*"},
+			minsize: 256,
+			maxsize: 1 << 20,
+		},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	etags := make(map[string]bool)
+	for _, tc := range []struct {
+		accept   string
+		encoding string
+	}{
+		{"br, gzip", "br"},
+		{"gzip", "gzip"},
+		{"gzip", "gzip"},
+		{"identity", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/512b.txt", bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", tc.accept)
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != http.StatusOK {
+			t.Error("status", got.Code)
+		}
+		if enc := got.Result().Header.Get("Content-Encoding"); enc != tc.encoding {
+			t.Error("content-encoding", tc.accept, enc)
+			continue
+		}
+		etags[got.Result().Header.Get("Etag")] = true
+		if vary := got.Result().Header.Get("Vary"); tc.encoding != "" && vary != "Accept-Encoding" {
+			t.Error("vary", tc.encoding, vary)
+		}
+		if got.Result().ContentLength != int64(got.Body.Len()) {
+			t.Error("content-length", got.Result().ContentLength, got.Body.Len())
+		}
+		var rd io.Reader
+		switch tc.encoding {
+		case "br":
+			rd = brotli.NewReader(got.Body)
+		case "gzip":
+			gz, err := gzip.NewReader(got.Body)
+			if err != nil {
+				t.Error("gzip reader", err)
+				continue
+			}
+			rd = gz
+		default:
+			rd = got.Body
+		}
+		data, err := io.ReadAll(rd)
+		if err != nil || len(data) != 512 {
+			t.Error("decode", tc.encoding, len(data), err)
+		}
+	}
+	if len(hdl.dynamic.cache.items) != 2 {
+		t.Error("cached", len(hdl.dynamic.cache.items))
+	}
+	if len(etags) != 3 {
+		t.Error("etag per encoding", etags)
+	}
+	// revalidation does not compress
+	hdl.dynamic.cache = NewDynamicCache(1 << 20)
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/512b.txt", bytes.NewBuffer([]byte{}))
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	req.Header.Set("If-None-Match", hdl.etag(hdl.getidx(hdl.methodmap["512b.txt"][zip.Store]), "br"))
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if got.Code != http.StatusNotModified {
+		t.Error("not modified", got.Code)
+	}
+	if len(hdl.dynamic.cache.items) != 0 {
+		t.Error("compressed for 304", len(hdl.dynamic.cache.items))
+	}
+}
+
+type blockingWriter struct {
+	io.Writer
+	wait chan struct{}
+}
+
+func (w blockingWriter) Close() error {
+	<-w.wait
+	return nil
+}
+
+func TestDynamicCompressOnce(t *testing.T) {
+	var calls atomic.Int32
+	wait := make(chan struct{})
+	dynamic_compressors["test"] = func(out io.Writer) (io.WriteCloser, error) {
+		calls.Add(1)
+		return blockingWriter{Writer: out, wait: wait}, nil
+	}
+	defer delete(dynamic_compressors, "test")
+	zr, err := zip.NewReader(bytes.NewReader(testzip), int64(len(testzip)))
+	if err != nil {
+		t.Error("zip", err)
+		return
+	}
+	d := DynamicCompress{cache: NewDynamicCache(1 << 20)}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := d.compress(zr.File[0], "test"); err != nil || uint64(len(data)) != zr.File[0].UncompressedSize64 {
+				t.Error("compress", len(data), err)
+			}
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Error("compressed", calls.Load())
+	}
+	if len(d.inflight) != 0 {
+		t.Error("inflight", len(d.inflight))
+	}
+}
+
+func TestDynamicCompressNotCompressible(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		dynamic: &DynamicCompress{
+			cache:   NewDynamicCache(1 << 20),
+			types:   []string{"application/json"},
+			minsize: 0,
+			maxsize: 1 << 20,
+		},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/512b.txt", bytes.NewBuffer([]byte{}))
+	req.Header.Set("Accept-Encoding", "gzip")
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if enc := got.Result().Header.Get("Content-Encoding"); enc != "" {
+		t.Error("content-encoding", enc)
+	}
+	if got.Body.Len() != 512 {
+		t.Error("length", got.Body.Len())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const idleWriteChunk = 1024 * 1024
+
+// connTracker counts connections by state
+type connTracker struct {
+	lock  sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]http.ConnState)}
+}
+
+// track is used as http.Server.ConnState
+func (t *connTracker) track(conn net.Conn, state http.ConnState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, conn)
+	default:
+		t.conns[conn] = state
+	}
+}
+
+// counts returns number of connections processing request, and others
+func (t *connTracker) counts() (int, int) {
+	if t == nil {
+		return 0, 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	active := 0
+	for _, state := range t.conns {
+		if state == http.StateActive {
+			active++
+		}
+	}
+	return active, len(t.conns) - active
+}
+
+// idleWriter extends write deadline on each write, instead of deadline of whole response
+type idleWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func newIdleWriter(w http.ResponseWriter, timeout time.Duration) *idleWriter {
+	res := &idleWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: timeout}
+	res.extend()
+	return res
+}
+
+func (w *idleWriter) extend() {
+	// error is ignored if not supported
+	_ = w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+}
+
+func (w *idleWriter) Write(b []byte) (int, error) {
+	w.extend()
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom copies by chunk to extend deadline. *io.LimitedReader is split into *io.LimitedReader of same source to keep sendfile
+func (w *idleWriter) ReadFrom(src io.Reader) (int64, error) {
+	var written int64
+	for {
+		var chunk *io.LimitedReader
+		lr, ok := src.(*io.LimitedReader)
+		if ok {
+			chunk = &io.LimitedReader{R: lr.R, N: min(lr.N, idleWriteChunk)}
+		} else {
+			chunk = &io.LimitedReader{R: src, N: idleWriteChunk}
+		}
+		size := chunk.N
+		w.extend()
+		n, err := io.Copy(w.ResponseWriter, chunk)
+		written += n
+		if ok {
+			lr.N -= n
+		}
+		if err != nil || n < size || (ok && lr.N == 0) {
+			return written, err
+		}
+	}
+}
+
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIdleWriterReadFrom(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte("0123456789abcdef"), idleWriteChunk/8)
+	w := httptest.NewRecorder()
+	iw := newIdleWriter(w, time.Second)
+	if n, err := iw.ReadFrom(bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Error("generic", n, err)
+	}
+	if !bytes.Equal(w.Body.Bytes(), data) {
+		t.Error("body", w.Body.Len())
+	}
+
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer fp.Close()
+	if _, err = fp.Seek(16, io.SeekStart); err != nil {
+		t.Error("seek", err)
+		return
+	}
+	sf := &sectionFile{LimitedReader: io.LimitedReader{R: fp, N: int64(len(data)) - 32}, fp: fp}
+	w = httptest.NewRecorder()
+	iw = newIdleWriter(w, time.Second)
+	if n, err := iw.ReadFrom(raw_source(sf)); err != nil || n != int64(len(data))-32 {
+		t.Error("section", n, err)
+	}
+	if !bytes.Equal(w.Body.Bytes(), data[16:len(data)-16]) || sf.N != 0 {
+		t.Error("section body", w.Body.Len(), sf.N)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cmd := WebServer{DrainTimeout: 200 * time.Millisecond, ReadyPath: []string{"/readyz"}}
+	cmd.handler.readypaths = cmd.ReadyPath
+	cmd.conns = newConnTracker()
+	cmd.server = http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}),
+		ConnState: cmd.conns.track,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("listen", err)
+		return
+	}
+	go cmd.server.Serve(listener)
+	defer close(release)
+	go http.Get("http://" + listener.Addr().String() + "/slow")
+	<-started
+	if active, _ := cmd.conns.counts(); active != 1 {
+		t.Error("active", active)
+	}
+	start := time.Now()
+	if err := cmd.Shutdown(); err != nil {
+		t.Error("shutdown", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Error("elapsed", elapsed)
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/readyz", nil)
+	w := httptest.NewRecorder()
+	statuscode := 0
+	if !cmd.handler.handle_health(w, req, &statuscode) || w.Code != http.StatusServiceUnavailable {
+		t.Error("ready", w.Code)
+	}
+}
+
+func TestShutdownDrainDelay(t *testing.T) {
+	t.Parallel()
+	cmd := WebServer{DrainDelay: 300 * time.Millisecond, DrainTimeout: time.Second}
+	cmd.conns = newConnTracker()
+	cmd.server = http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		ConnState: cmd.conns.track,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("listen", err)
+		return
+	}
+	go cmd.server.Serve(listener)
+	done := make(chan error)
+	go func() {
+		done <- cmd.Shutdown()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !cmd.handler.draining.Load() {
+		t.Error("not draining")
+	}
+	// new connection is accepted during delay
+	client := http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Error("get", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Error("status", resp.StatusCode)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Error("shutdown", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type ImageOption struct {
+	// Formats are extensions of variants in order of preference
+	Formats []string
+	// Naming is template of variant name. {name}, {base} and {ext} are replaced
+	Naming string
+}
+
+type imageVariant struct {
+	name  string
+	ctype string
+}
+
+// parse_accept parses Accept-like header and returns quality by value
+func parse_accept(header string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, v := range strings.Split(header, ",") {
+		params := strings.Split(v, ";")
+		key := strings.ToLower(strings.TrimSpace(params[0]))
+		if key == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			if val, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(val, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		res[key] = q
+	}
+	return res
+}
+
+func (opt *ImageOption) variant_name(name string, ext string) string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	return strings.NewReplacer("{name}", name, "{base}", base, "{ext}", ext).Replace(opt.Naming)
+}
+
+// image_variants finds sibling variants of each entry
+func (opt *ImageOption) image_variants(methodmap map[string]map[uint16]int) map[string][]imageVariant {
+	res := make(map[string][]imageVariant)
+	for name := range methodmap {
+		if !strings.HasPrefix(mime.TypeByExtension(path.Ext(name)), "image/") {
+			continue
+		}
+		for _, ext := range opt.Formats {
+			if strings.EqualFold(path.Ext(name), "."+ext) {
+				continue
+			}
+			vname := opt.variant_name(name, ext)
+			if _, ok := methodmap[vname]; !ok || vname == name {
+				continue
+			}
+			ctype, _, _ := strings.Cut(mime.TypeByExtension("."+ext), ";")
+			if ctype == "" {
+				slog.Warn("unknown image format", "ext", ext)
+				continue
+			}
+			res[name] = append(res[name], imageVariant{name: vname, ctype: ctype})
+		}
+	}
+	slog.Debug("image variants", "files", len(res))
+	return res
+}
+
+// negotiate_image returns name of best variant which client accepts
+func (h *ZipHandler) negotiate_image(w http.ResponseWriter, r *http.Request, fname string) string {
+	variants, ok := h.imagevariants[fname]
+	if !ok {
+		return fname
+	}
+	w.Header().Add("Vary", "Accept")
+	accepts := parse_accept(r.Header.Get("Accept"))
+	for _, v := range variants {
+		if q, ok := accepts[v.ctype]; ok && q > 0 {
+			slog.Debug("image variant", "name", fname, "variant", v.name)
+			return v.name
+		}
+	}
+	return fname
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func image_testzip(t *testing.T, names ...string) []byte {
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	for _, name := range names {
+		ofp, err := wr.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal("create", err)
+		}
+		if _, err = ofp.Write([]byte(name)); err != nil {
+			t.Fatal("write", err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal("close", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseAccept(t *testing.T) {
+	t.Parallel()
+	res := parse_accept("image/avif;q=0, image/webp, */*;q=0.8, ")
+	if len(res) != 3 {
+		t.Error("length", res)
+	}
+	if res["image/avif"] != 0 || res["image/webp"] != 1 || res["*/*"] != 0.8 {
+		t.Error("quality", res)
+	}
+}
+
+func TestImageVariant(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		naming   string
+		path     string
+		accept   string
+		expected string
+		ctype    string
+		vary     bool
+	}{
+		{"{name}.{ext}", "/hero.jpg", "image/avif,image/webp,*/*", "hero.jpg.avif", "image/avif", true},
+		{"{name}.{ext}", "/hero.jpg", "image/avif;q=0,image/webp,*/*", "hero.jpg.webp", "image/webp", true},
+		{"{name}.{ext}", "/hero.jpg", "*/*", "hero.jpg", "image/jpeg", true},
+		{"{name}.{ext}", "/logo.png", "image/avif,image/webp,*/*", "logo.png", "image/png", false},
+		{"{base}.{ext}", "/logo.png", "image/avif,image/webp,*/*", "logo.webp", "image/webp", true},
+		{"{base}.{ext}", "/hero.jpg", "image/avif,image/webp,*/*", "hero.jpg", "image/jpeg", false},
+	} {
+		hdl := ZipHandler{
+			indexname: "index.html",
+			methodmap: make(map[string]map[uint16]int),
+			imageopt:  &ImageOption{Formats: []string{"avif", "webp"}, Naming: tc.naming},
+		}
+		data := image_testzip(t, "hero.jpg", "hero.jpg.avif", "hero.jpg.webp", "logo.png", "logo.webp")
+		if err := hdl.initialize_memory([][]byte{data}); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept", tc.accept)
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != http.StatusOK {
+			t.Error("status", tc.path, got.Code)
+		}
+		if got.Body.String() != tc.expected {
+			t.Error("body", tc.naming, tc.path, tc.accept, got.Body.String())
+		}
+		if ctype := got.Result().Header.Get("Content-Type"); ctype != tc.ctype {
+			t.Error("content-type", tc.expected, ctype)
+		}
+		if vary := got.Result().Header.Get("Vary"); (vary == "Accept") != tc.vary {
+			t.Error("vary", tc.naming, tc.path, vary)
+		}
+	}
+}
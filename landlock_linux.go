@@ -0,0 +1,110 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	landlockReadAccess = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// landlockFileAccess is access rights of regular file
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// landlock_access returns handled access rights of ABI version
+func landlock_access(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return access
+}
+
+func landlock_abi() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock is not available: %w", errno)
+	}
+	return int(abi), nil
+}
+
+// landlock_add adds rule to allow access beneath path
+func landlock_add(ruleset int, name string, access uint64) error {
+	fd, err := unix.Open(name, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("landlock %s: %w", name, err)
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err = unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("landlock %s: %w", name, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock %s: %w", name, errno)
+	}
+	return nil
+}
+
+// landlock_ruleset creates ruleset allows ro and rw paths. other paths are denied
+func landlock_ruleset(ro []string, rw []string) (int, error) {
+	abi, err := landlock_abi()
+	if err != nil {
+		return -1, err
+	}
+	handled := landlock_access(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr.Access_fs), 0)
+	if errno != 0 {
+		return -1, fmt.Errorf("landlock create ruleset: %w", errno)
+	}
+	for _, name := range ro {
+		if err = landlock_add(int(fd), name, landlockReadAccess); err != nil {
+			unix.Close(int(fd))
+			return -1, err
+		}
+	}
+	for _, name := range rw {
+		if err = landlock_add(int(fd), name, handled&^uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE)); err != nil {
+			unix.Close(int(fd))
+			return -1, err
+		}
+	}
+	slog.Info("landlock ruleset", "abi", abi, "read", ro, "write", rw)
+	return int(fd), nil
+}
+
+// landlock_restrict applies ruleset to all threads. it requires CGO_ENABLED=0
+func landlock_restrict(ruleset int) error {
+	defer unix.Close(ruleset)
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("landlock requires binary built with CGO_ENABLED=0")
+		}
+		return fmt.Errorf("no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("landlock restrict: %w", errno)
+	}
+	return nil
+}
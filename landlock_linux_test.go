@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLandlockAccess(t *testing.T) {
+	t.Parallel()
+	if landlock_access(1)&unix.LANDLOCK_ACCESS_FS_REFER != 0 {
+		t.Error("refer in abi 1")
+	}
+	if landlock_access(3)&unix.LANDLOCK_ACCESS_FS_TRUNCATE == 0 {
+		t.Error("no truncate in abi 3")
+	}
+}
+
+// TestLandlockChild runs in subprocess not to restrict test process
+func TestLandlockChild(t *testing.T) {
+	dir := os.Getenv("ZIPHTTP_LANDLOCK_DIR")
+	if dir == "" {
+		t.Skip("not a child")
+	}
+	if err := drop_privileges(PrivOption{Landlock: true, ReadPaths: []string{dir}}); err != nil {
+		t.Skip("landlock: ", err)
+	}
+	if _, err := os.ReadFile(filepath.Join(dir, "allowed")); err != nil {
+		t.Error("allowed", err)
+	}
+	if _, err := os.ReadFile("/etc/passwd"); err == nil {
+		t.Error("not restricted")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "written"), []byte{}, 0o644); err == nil {
+		t.Error("writable")
+	}
+}
+
+func TestLandlock(t *testing.T) {
+	t.Parallel()
+	if _, err := landlock_abi(); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "allowed"), []byte("ok"), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	child := exec.Command(os.Args[0], "-test.run=^TestLandlockChild$", "-test.v")
+	child.Env = append(os.Environ(), "ZIPHTTP_LANDLOCK_DIR="+dir)
+	output, err := child.CombinedOutput()
+	if err != nil {
+		t.Error("child", err, string(output))
+	}
+	if strings.Contains(string(output), "SKIP") {
+		t.Log(string(output))
+	}
+}
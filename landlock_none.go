@@ -0,0 +1,13 @@
+//go:build unix && !linux
+
+package main
+
+import "errors"
+
+func landlock_ruleset(ro []string, rw []string) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+func landlock_restrict(ruleset int) error {
+	return errors.ErrUnsupported
+}
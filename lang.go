@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+type LangOption struct {
+	Languages []string
+	Default   string
+	Cookie    string
+	Query     string
+	Redirect  bool
+}
+
+// accept_language returns languages in order of quality
+func accept_language(header string) []string {
+	accepts := parse_accept(header)
+	res := make([]string, 0, len(accepts))
+	for k, q := range accepts {
+		if q > 0 {
+			res = append(res, k)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b string) int {
+		if accepts[a] != accepts[b] {
+			if accepts[a] > accepts[b] {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return res
+}
+
+// match_language returns available language of lang. "en-US" matches "en"
+func (opt *LangOption) match_language(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	for _, v := range opt.Languages {
+		if strings.ToLower(v) == lang {
+			return v
+		}
+	}
+	if primary, _, ok := strings.Cut(lang, "-"); ok {
+		for _, v := range opt.Languages {
+			if strings.ToLower(v) == primary {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// preferred_languages returns available languages by query, cookie, Accept-Language, and default in this order
+func (opt *LangOption) preferred_languages(r *http.Request) []string {
+	res := make([]string, 0, len(opt.Languages))
+	add := func(lang string) {
+		if lang != "" && !slices.Contains(res, lang) {
+			res = append(res, lang)
+		}
+	}
+	if opt.Query != "" {
+		add(opt.match_language(r.URL.Query().Get(opt.Query)))
+	}
+	if opt.Cookie != "" {
+		if cookie, err := r.Cookie(opt.Cookie); err == nil {
+			add(opt.match_language(cookie.Value))
+		}
+	}
+	for _, v := range accept_language(r.Header.Get("Accept-Language")) {
+		add(opt.match_language(v))
+	}
+	add(opt.Default)
+	return res
+}
+
+// localized returns names of localized variant: index.en.html and en/index.html
+func localized(fname string, lang string) []string {
+	ext := path.Ext(fname)
+	return []string{
+		strings.TrimSuffix(fname, ext) + "." + lang + ext,
+		lang + "/" + fname,
+	}
+}
+
+func (opt *LangOption) vary(w http.ResponseWriter) {
+	w.Header().Add("Vary", "Accept-Language")
+	if opt.Cookie != "" {
+		w.Header().Add("Vary", "Cookie")
+	}
+}
+
+// negotiate_language returns name of localized variant. redirect is set when root should be redirected
+func (h *ZipHandler) negotiate_language(w http.ResponseWriter, r *http.Request, fname string) (string, string) {
+	opt := h.langopt
+	// localized tree is under the directory mapped to root of url
+	prefix := strings.Trim(h.stripprefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	rel, ok := strings.CutPrefix(fname, prefix)
+	if !ok {
+		return fname, ""
+	}
+	if first, _, ok := strings.Cut(rel, "/"); ok && slices.Contains(opt.Languages, first) {
+		// already localized tree
+		w.Header().Set("Content-Language", first)
+		return fname, ""
+	}
+	variants := make(map[string]string)
+	for _, lang := range opt.Languages {
+		for _, name := range localized(rel, lang) {
+			if h.exists(prefix + name) {
+				variants[lang] = prefix + name
+				break
+			}
+		}
+	}
+	if len(variants) == 0 {
+		return fname, ""
+	}
+	opt.vary(w)
+	langs := opt.preferred_languages(r)
+	if !h.exists(fname) {
+		// any variant is better than not found
+		langs = append(langs, opt.Languages...)
+	}
+	for _, lang := range langs {
+		name, ok := variants[lang]
+		if !ok {
+			continue
+		}
+		if opt.Redirect && rel == h.indexname && name == prefix+lang+"/"+rel {
+			// "/" and "/index.html" go to "/<lang>/"
+			dir := clean_path(r.URL.Path)
+			if !strings.HasSuffix(dir, "/") {
+				dir = path.Dir(dir)
+			}
+			return fname, path.Join(dir, lang) + "/"
+		}
+		slog.Debug("localized", "name", fname, "lang", lang, "variant", name)
+		w.Header().Set("Content-Language", lang)
+		return name, ""
+	}
+	slog.Debug("no localized variant", "name", fname, "languages", langs)
+	return fname, ""
+}
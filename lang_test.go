@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAcceptLanguage(t *testing.T) {
+	t.Parallel()
+	res := accept_language("ja;q=0.5, en-US, fr;q=0, de;q=0.8")
+	if !slices.Equal(res, []string{"en-us", "de", "ja"}) {
+		t.Error("order", res)
+	}
+	opt := LangOption{Languages: []string{"en", "ja"}}
+	if lang := opt.match_language("en-US"); lang != "en" {
+		t.Error("match", lang)
+	}
+	if lang := opt.match_language("fr"); lang != "" {
+		t.Error("not match", lang)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		langopt:   &LangOption{Languages: []string{"en", "ja"}, Default: "en", Cookie: "lang", Query: "hl", Redirect: true},
+	}
+	data := image_testzip(t, "index.html", "en/index.html", "ja/index.html", "about.html", "about.en.html", "about.ja.html", "plain.html", "en/only.html",
+		"guide.ja.html", "feature.html", "feature.ja.html")
+	if err := hdl.initialize_memory([][]byte{data}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	for _, tc := range []struct {
+		path     string
+		accept   string
+		cookie   string
+		status   int
+		expected string
+		lang     string
+	}{
+		{"/about.html", "ja,en;q=0.5", "", http.StatusOK, "about.ja.html", "ja"},
+		{"/about.html", "fr, en-US;q=0.8", "", http.StatusOK, "about.en.html", "en"},
+		{"/about.html", "fr", "", http.StatusOK, "about.en.html", "en"},
+		{"/about.html?hl=ja", "en", "", http.StatusOK, "about.ja.html", "ja"},
+		{"/about.html", "en", "ja", http.StatusOK, "about.ja.html", "ja"},
+		{"/about.html?hl=xx", "en", "ja", http.StatusOK, "about.ja.html", "ja"},
+		{"/plain.html", "ja", "", http.StatusOK, "plain.html", ""},
+		{"/en/only.html", "ja", "", http.StatusOK, "en/only.html", "en"},
+		{"/", "ja", "", http.StatusFound, "/ja/", ""},
+		{"/index.html", "ja", "", http.StatusFound, "/ja/", ""},
+		{"/guide.html", "en, ja;q=0.5", "", http.StatusOK, "guide.ja.html", "ja"},
+		{"/guide.html", "fr", "", http.StatusOK, "guide.ja.html", "ja"},
+		{"/feature.html", "fr", "", http.StatusOK, "feature.html", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Language", tc.accept)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "lang", Value: tc.cookie})
+		}
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != tc.status {
+			t.Error("status", tc.path, got.Code)
+		}
+		if tc.status == http.StatusFound {
+			if loc := got.Result().Header.Get("Location"); loc != tc.expected {
+				t.Error("location", tc.path, loc)
+			}
+		} else if got.Body.String() != tc.expected {
+			t.Error("body", tc.path, tc.accept, tc.cookie, got.Body.String())
+		}
+		if lang := got.Result().Header.Get("Content-Language"); lang != tc.lang {
+			t.Error("content-language", tc.path, lang)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/about.html", bytes.NewBuffer([]byte{}))
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if vary := got.Result().Header.Values("Vary"); !slices.Equal(vary, []string{"Accept-Language", "Cookie"}) {
+		t.Error("vary", vary)
+	}
+}
+
+func TestLanguagePrefix(t *testing.T) {
+	t.Parallel()
+	data := image_testzip(t, "site/index.html", "site/en/index.html", "site/ja/index.html", "site/about.ja.html")
+	for _, tc := range []struct {
+		addprefix string
+		path      string
+		status    int
+		expected  string
+		lang      string
+	}{
+		{"", "/", http.StatusFound, "/ja/", ""},
+		{"/app", "/app/", http.StatusFound, "/app/ja/", ""},
+		{"/app", "/app/index.html", http.StatusFound, "/app/ja/", ""},
+		{"", "/ja/", http.StatusOK, "site/ja/index.html", "ja"},
+		{"", "/about.html", http.StatusOK, "site/about.ja.html", "ja"},
+	} {
+		hdl := ZipHandler{
+			indexname:   "index.html",
+			stripprefix: "/site",
+			addprefix:   tc.addprefix,
+			methodmap:   make(map[string]map[uint16]int),
+			langopt:     &LangOption{Languages: []string{"en", "ja"}, Default: "en", Redirect: true},
+		}
+		if err := hdl.initialize_memory([][]byte{data}); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Language", "ja")
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != tc.status {
+			t.Error("status", tc.path, got.Code)
+		}
+		if tc.status == http.StatusFound {
+			if loc := got.Result().Header.Get("Location"); loc != tc.expected {
+				t.Error("location", tc.path, loc)
+			}
+		} else if got.Body.String() != tc.expected {
+			t.Error("body", tc.path, got.Body.String())
+		}
+		if lang := got.Result().Header.Get("Content-Language"); lang != tc.lang {
+			t.Error("content-language", tc.path, lang)
+		}
+	}
+}
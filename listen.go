@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is first fd passed by systemd socket activation
+const listenFdsStart = 3
+
+// systemd_listeners returns listeners passed by systemd(LISTEN_PID, LISTEN_FDS). empty if not activated
+func systemd_listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	res := make([]net.Listener, 0, nfds)
+	for i := range nfds {
+		name := fmt.Sprintf("LISTEN_FD_%d", listenFdsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		fp := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(fp)
+		fp.Close()
+		if err != nil {
+			for _, l := range res {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation %s: %w", name, err)
+		}
+		slog.Info("socket activation", "name", name, "listen", listener.Addr())
+		res = append(res, listener)
+	}
+	return res, nil
+}
+
+// lookup_owner returns uid and gid of "user", "user:group" or ":group". -1 is unchanged
+func lookup_owner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	username, groupname, _ := strings.Cut(owner, ":")
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			if u, err = user.LookupId(username); err != nil {
+				return uid, gid, err
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return uid, gid, err
+		}
+		if groupname == "" {
+			if gid, err = strconv.Atoi(u.Gid); err != nil {
+				return uid, gid, err
+			}
+		}
+	}
+	if groupname != "" {
+		g, err := user.LookupGroup(groupname)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupname); err != nil {
+				return uid, gid, err
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return uid, gid, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// set_socket_perm changes mode and owner of unix socket file
+func set_socket_perm(listener net.Listener, mode string, owner string) error {
+	if listener.Addr().Network() != "unix" {
+		return nil
+	}
+	name := listener.Addr().String()
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode %s: %w", mode, err)
+		}
+		if err = os.Chmod(name, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+	if owner != "" {
+		uid, gid, err := lookup_owner(owner)
+		if err != nil {
+			return err
+		}
+		if err = os.Chown(name, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove_stale_socket removes unix socket file left by previous process. socket in use is kept
+func remove_stale_socket(name string) {
+	if st, err := os.Lstat(name); err != nil || st.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.Dial("unix", name)
+	if err == nil {
+		conn.Close()
+		slog.Warn("socket is in use", "name", name)
+		return
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		slog.Warn("check stale socket", "name", name, "error", err)
+		return
+	}
+	slog.Info("remove stale socket", "name", name)
+	if err = os.Remove(name); err != nil {
+		slog.Warn("remove stale socket", "name", name, "error", err)
+	}
+}
+
+// open_listeners opens listeners of systemd socket activation, or --listen
+func (cmd *WebServer) open_listeners() ([]net.Listener, error) {
+	res, err := systemd_listeners()
+	if err != nil || len(res) != 0 {
+		return res, err
+	}
+	for _, addr := range cmd.Listen {
+		listener, err := do_listen(addr)
+		if err == nil {
+			err = set_socket_perm(listener, cmd.SocketMode, cmd.SocketOwner)
+		}
+		if err != nil {
+			slog.Error("listen error", "listen", addr, "error", err)
+			if listener != nil {
+				listener.Close()
+			}
+			for _, l := range res {
+				l.Close()
+			}
+			return nil, err
+		}
+		res = append(res, listener)
+	}
+	return res, nil
+}
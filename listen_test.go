@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSystemdListenersNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	res, err := systemd_listeners()
+	if err != nil || len(res) != 0 {
+		t.Error("activated", res, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("environment is removed")
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	t.Parallel()
+	cur, err := user.Current()
+	if err != nil {
+		t.Skip("current user", err)
+	}
+	uid, gid, err := lookup_owner(cur.Username)
+	if err != nil {
+		t.Error("lookup", err)
+		return
+	}
+	if strconv.Itoa(uid) != cur.Uid || strconv.Itoa(gid) != cur.Gid {
+		t.Error("owner", uid, gid, cur.Uid, cur.Gid)
+	}
+	if uid, gid, err = lookup_owner(":" + cur.Gid); err != nil || uid != -1 || strconv.Itoa(gid) != cur.Gid {
+		t.Error("group", uid, gid, err)
+	}
+	if _, _, err = lookup_owner("no-such-user-ziphttp"); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestUnixSocketPerm(t *testing.T) {
+	t.Parallel()
+	name := filepath.Join(t.TempDir(), "ziphttp.sock")
+	listener, err := do_listen("unix:" + name)
+	if err != nil {
+		t.Skip("unix socket", err)
+	}
+	if err = set_socket_perm(listener, "0600", ""); err != nil {
+		t.Error("perm", err)
+	}
+	if st, err := os.Stat(name); err != nil || st.Mode().Perm() != 0o600 {
+		t.Error("mode", st.Mode(), err)
+	}
+	if err = set_socket_perm(listener, "999", ""); err == nil {
+		t.Error("no error")
+	}
+	// simulate socket left by killed process
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if listener, err = do_listen("unix:" + name); err != nil {
+		t.Error("stale socket", err)
+		return
+	}
+	defer listener.Close()
+	// socket in use is not removed
+	if second, err := do_listen("unix:" + name); err == nil {
+		second.Close()
+		t.Error("socket in use is replaced")
+	}
+	if conn, err := net.Dial("unix", name); err != nil {
+		t.Error("dial", err)
+	} else {
+		conn.Close()
+	}
+}
+
+func TestOpenListeners(t *testing.T) {
+	t.Parallel()
+	cmd := WebServer{Listen: []string{"127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "ziphttp.sock")}}
+	listeners, err := cmd.open_listeners()
+	if err != nil {
+		t.Error("listen", err)
+		return
+	}
+	for _, l := range listeners {
+		l.Close()
+	}
+	if len(listeners) != 2 || listeners[1].Addr().Network() != "unix" {
+		t.Error("listeners", listeners)
+	}
+	cmd.Listen = append(cmd.Listen, "bad://addr")
+	if _, err = cmd.open_listeners(); err == nil {
+		t.Error("no error")
+	}
+}
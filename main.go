@@ -7,14 +7,17 @@ import (
 	"github.com/jessevdk/go-flags"
 )
 
-var globalOption struct {
+type GlobalOption struct {
 	Verbose bool           `short:"v" long:"verbose" description:"show verbose logs"`
 	Quiet   bool           `short:"q" long:"quiet" description:"suppress logs"`
 	JsonLog bool           `long:"json-log" description:"use json format for logging"`
 	Archive flags.Filename `short:"f" long:"archive" description:"archive file" env:"ZIPHTTP_ARCHIVE"`
 	Self    bool           `long:"self" description:"use executable zip" env:"ZIPHTTP_SELF"`
+	Config  flags.Filename `long:"config" description:"configuration file(YAML or TOML). flags and ZIPHTTP_* env override it" env:"ZIPHTTP_CONFIG"`
 }
 
+var globalOption GlobalOption
+
 func archiveFilename() string {
 	if globalOption.Self {
 		res, err := os.Executable()
@@ -56,10 +59,12 @@ func realMain() int {
 		{Name: "testlink", Short: "test link rewrite", Long: "test rewrite link to relative", Data: &LinkCommand{}},
 		{Name: "zipsort", Short: "sort zip", Long: "sort zip by name", Data: &ZipSort{}},
 		{Name: "zip", Short: "create zip", Long: "create new archive from dir/file/zip", Data: &ZipCmd{}},
+		{Name: "sidecar", Short: "create index sidecar", Long: "create index sidecar for fast startup", Data: &SidecarCmd{}},
 		{Name: "install-skill", Short: "install skill", Long: "install ziphttp skill to user environment", Data: &InstallSkillCmd{}},
 		{Name: "version", Short: "show version", Long: "show version and exit", Data: &VersionCmd{}},
+		{Name: "config", Short: "show configuration", Long: "validate and show effective configuration of webserver", Data: &ConfigCmd{}},
 	}
-	parser := flags.NewParser(&globalOption, flags.Default)
+	parser := flags.NewParser(&globalOption, flags.Default|flags.AllowBoolValues)
 	for _, cmd := range commands {
 		_, err = parser.AddCommand(cmd.Name, cmd.Short, cmd.Long, cmd.Data)
 		if err != nil {
@@ -67,7 +72,15 @@ func realMain() int {
 			return -1
 		}
 	}
-	if _, err := parser.Parse(); err != nil {
+	commandArgs = os.Args[1:]
+	if exe, err := os.Executable(); err == nil {
+		commandArgs = self_args(parser, commandArgs, exe)
+	}
+	if err = setup_config(parser, commandArgs); err != nil {
+		slog.Error("config", "error", err)
+		return 1
+	}
+	if _, err := parser.ParseArgs(commandArgs); err != nil {
 		if _, ok := err.(*flags.Error); ok {
 			return 0
 		}
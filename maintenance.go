@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceOption configures maintenance mode
+type MaintenanceOption struct {
+	Page       string
+	RetryAfter time.Duration
+	Paths      []string
+	Networks   []*net.IPNet
+	FlagFile   string
+}
+
+// Maintenance is enabled manually(signal, admin endpoint) or by existence of flag file
+type Maintenance struct {
+	opt    MaintenanceOption
+	manual atomic.Bool
+	file   atomic.Bool
+}
+
+func NewMaintenance(opt MaintenanceOption, enabled bool) *Maintenance {
+	res := Maintenance{opt: opt}
+	res.manual.Store(enabled)
+	res.check_file()
+	return &res
+}
+
+func (m *Maintenance) Enabled() bool {
+	return m.manual.Load() || m.file.Load()
+}
+
+func (m *Maintenance) Set(enabled bool) {
+	if m.manual.Swap(enabled) != enabled {
+		slog.Info("maintenance mode", "enabled", enabled)
+	}
+}
+
+func (m *Maintenance) Toggle() {
+	m.Set(!m.manual.Load())
+}
+
+func (m *Maintenance) check_file() {
+	if m.opt.FlagFile == "" {
+		return
+	}
+	_, err := os.Stat(m.opt.FlagFile)
+	exists := err == nil
+	if m.file.Swap(exists) != exists {
+		slog.Info("maintenance flag file", "name", m.opt.FlagFile, "exists", exists)
+	}
+}
+
+// watch_file checks flag file by interval
+func (m *Maintenance) watch_file(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check_file()
+		}
+	}
+}
+
+// active returns true if the request should get maintenance page
+func (m *Maintenance) active(r *http.Request) bool {
+	if !m.Enabled() {
+		return false
+	}
+	urlpath := clean_path(r.URL.Path)
+	if slices.ContainsFunc(m.opt.Paths, func(pat string) bool { return match_path(pat, urlpath) }) {
+		return false
+	}
+	if ip := remote_ip(r); ip != nil && contains_ip(m.opt.Networks, ip) {
+		return false
+	}
+	return true
+}
+
+// send_maintenance responds 503 with maintenance page in archive
+func (h *ZipHandler) send_maintenance(w http.ResponseWriter, r *http.Request, statuscode *int) {
+	*statuscode = http.StatusServiceUnavailable
+	opt := h.maintenance.opt
+	if opt.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(opt.RetryAfter.Seconds()))))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	var fi *zip.File
+	idx := -1
+	for _, method := range []uint16{zip.Store, zip.Deflate} {
+		if v, ok := h.methodmap[opt.Page][method]; ok {
+			idx = v
+			fi = h.getidx(idx)
+			break
+		}
+	}
+	if fi == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(*statuscode)
+		fmt.Fprint(w, "service unavailable")
+		return
+	}
+	rd, err := fi.Open()
+	if err != nil {
+		slog.Error("open maintenance page", "name", opt.Page, "error", err)
+		w.WriteHeader(*statuscode)
+		return
+	}
+	defer rd.Close()
+	if ctype := h.contenttype(idx, fi); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Content-Length", strconv.FormatUint(fi.UncompressedSize64, 10))
+	w.WriteHeader(*statuscode)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if written, err := io.Copy(w, rd); err != nil {
+		slog.Error("send maintenance page", "name", opt.Page, "written", written, "error", err)
+	}
+}
+
+// ServeHTTP of admin endpoint. GET shows state, PUT enables and DELETE disables maintenance mode
+func (m *Maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		m.Set(true)
+	case http.MethodDelete:
+		m.Set(false)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]bool{
+		"maintenance": m.Enabled(),
+		"manual":      m.manual.Load(),
+		"file":        m.file.Load(),
+	}); err != nil {
+		slog.Error("admin response", "error", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	t.Parallel()
+	flagfile := filepath.Join(t.TempDir(), "maintenance")
+	allow, _ := parse_networks([]string{"10.0.0.0/8"})
+	maint := NewMaintenance(MaintenanceOption{
+		Page:       "maintenance.html",
+		RetryAfter: 90 * time.Second,
+		Paths:      []string{"/status/"},
+		Networks:   allow,
+		FlagFile:   flagfile,
+	}, false)
+	hdl := ZipHandler{
+		indexname:   "index.html",
+		methodmap:   make(map[string]map[uint16]int),
+		maintenance: maint,
+		healthpaths: []string{"/healthz"},
+	}
+	if err := hdl.initialize_memory([][]byte{image_testzip(t, "index.html", "maintenance.html", "status/index.html")}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	get := func(remote string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+path, bytes.NewBuffer([]byte{}))
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		hdl.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("192.0.2.1:1234", "/index.html"); w.Code != http.StatusOK || w.Body.String() != "index.html" {
+		t.Error("normal", w.Code, w.Body.String())
+	}
+	maint.Toggle()
+	w := get("192.0.2.1:1234", "/index.html")
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "maintenance.html" || w.Header().Get("Retry-After") != "90" {
+		t.Error("maintenance", w.Code, w.Body.String(), w.Header())
+	}
+	if w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Error("content-type", w.Header().Get("Content-Type"))
+	}
+	for _, tc := range []struct {
+		remote string
+		path   string
+	}{
+		{"10.0.0.1:1234", "/index.html"},
+		{"192.0.2.1:1234", "/status/index.html"},
+		{"192.0.2.1:1234", "/healthz"},
+	} {
+		if w := get(tc.remote, tc.path); w.Code != http.StatusOK {
+			t.Error("allowed", tc, w.Code)
+		}
+	}
+	if w := get("192.0.2.1:1234", "/status/../index.html"); w.Code != http.StatusServiceUnavailable {
+		t.Error("allowed by unclean path", w.Code)
+	}
+	maint.Toggle()
+	if err := os.WriteFile(flagfile, []byte{}, 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	maint.check_file()
+	if w := get("192.0.2.1:1234", "/index.html"); w.Code != http.StatusServiceUnavailable {
+		t.Error("flag file", w.Code)
+	}
+	if err := os.Remove(flagfile); err != nil {
+		t.Error("remove", err)
+	}
+	maint.check_file()
+	if maint.Enabled() {
+		t.Error("enabled after flag file removed")
+	}
+}
+
+func TestMaintenanceAdmin(t *testing.T) {
+	t.Parallel()
+	cmd := WebServer{}
+	cmd.handler.maintenance = NewMaintenance(MaintenanceOption{}, false)
+	srv := httptest.NewServer(cmd.admin_handler())
+	defer srv.Close()
+	for _, tc := range []struct {
+		method  string
+		path    string
+		status  int
+		enabled bool
+	}{
+		{http.MethodGet, "/healthz", http.StatusOK, false},
+		{http.MethodPut, "/maintenance", http.StatusOK, true},
+		{http.MethodGet, "/maintenance", http.StatusOK, true},
+		{http.MethodDelete, "/maintenance", http.StatusOK, false},
+		{http.MethodPatch, "/maintenance", http.StatusMethodNotAllowed, false},
+		{http.MethodGet, "/debug/vars", http.StatusOK, false},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		if err != nil {
+			t.Error("request", err)
+			continue
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error("do", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || cmd.handler.maintenance.Enabled() != tc.enabled {
+			t.Error("admin", tc, resp.StatusCode, cmd.handler.maintenance.Enabled())
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ZipMetaExtraID is header ID of ziphttp extra field ("zh")
+const ZipMetaExtraID uint16 = 0x687a
+
+const HeadersSuffix = ".headers"
+
+// ZipMeta is per-entry HTTP metadata stored in extra field as JSON
+type ZipMeta struct {
+	Headers         map[string]string `json:"headers,omitempty"`
+	Redirect        string            `json:"redirect,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	CacheControl    string            `json:"cache-control,omitempty"`
+	Digest          string            `json:"digest,omitempty"`
+	UseAsDictionary string            `json:"use-as-dictionary,omitempty"`
+	Preload         []string          `json:"preload,omitempty"`
+}
+
+func (m *ZipMeta) empty() bool {
+	return len(m.Headers) == 0 && m.Redirect == "" && m.Status == 0 && m.CacheControl == "" && m.Digest == "" && m.UseAsDictionary == "" && len(m.Preload) == 0
+}
+
+// validate checks status is redirection
+func (m *ZipMeta) validate() error {
+	if m.Status != 0 && (m.Status < 300 || m.Status > 399) {
+		return fmt.Errorf("status should be 3xx: %d", m.Status)
+	}
+	return nil
+}
+
+// merge overwrites m by non-empty values of other
+func (m *ZipMeta) merge(other *ZipMeta) {
+	if len(other.Headers) != 0 {
+		if m.Headers == nil {
+			m.Headers = make(map[string]string)
+		}
+		maps.Copy(m.Headers, other.Headers)
+	}
+	if other.Redirect != "" {
+		m.Redirect = other.Redirect
+	}
+	if other.Status != 0 {
+		m.Status = other.Status
+	}
+	if other.CacheControl != "" {
+		m.CacheControl = other.CacheControl
+	}
+	if other.Digest != "" {
+		m.Digest = other.Digest
+	}
+	if other.UseAsDictionary != "" {
+		m.UseAsDictionary = other.UseAsDictionary
+	}
+	if len(other.Preload) != 0 {
+		m.Preload = other.Preload
+	}
+}
+
+// split_extra returns payload of ziphttp field and other fields
+func split_extra(extra []byte) ([]byte, []byte) {
+	var payload []byte
+	rest := make([]byte, 0, len(extra))
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id == ZipMetaExtraID {
+			payload = extra[4 : 4+size]
+		} else {
+			rest = append(rest, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return payload, rest
+}
+
+// ParseZipMeta reads ziphttp field from extra. returns nil if not exists
+func ParseZipMeta(extra []byte) (*ZipMeta, error) {
+	payload, _ := split_extra(extra)
+	if payload == nil {
+		return nil, nil
+	}
+	var res ZipMeta
+	if err := json.Unmarshal(payload, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetZipMeta replaces ziphttp field of fh
+func SetZipMeta(fh *zip.FileHeader, meta *ZipMeta) error {
+	payload, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if len(payload) > 0xffff {
+		return fmt.Errorf("metadata too large: %d", len(payload))
+	}
+	_, rest := split_extra(fh.Extra)
+	res := binary.LittleEndian.AppendUint16(rest, ZipMetaExtraID)
+	res = binary.LittleEndian.AppendUint16(res, uint16(len(payload)))
+	fh.Extra = append(res, payload...)
+	return nil
+}
+
+// MetaRule applies metadata to entries match pattern
+type MetaRule struct {
+	Pattern string `json:"pattern"`
+	ZipMeta
+}
+
+func LoadMetaRules(filename string) ([]MetaRule, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var res []MetaRule
+	if err = json.NewDecoder(fp).Decode(&res); err != nil {
+		return nil, err
+	}
+	for _, rule := range res {
+		if err = rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Pattern, err)
+		}
+	}
+	return res, nil
+}
+
+// ReadHeadersFile reads "Name: value" lines. Location, Status and Cache-Control are special
+func ReadHeadersFile(filename string) (*ZipMeta, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	res := ZipMeta{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header line: %s", line)
+		}
+		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch k {
+		case "Location":
+			res.Redirect = v
+		case "Status":
+			if res.Status, err = strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+		case "Cache-Control":
+			res.CacheControl = v
+		default:
+			if res.Headers == nil {
+				res.Headers = make(map[string]string)
+			}
+			res.Headers[k] = v
+		}
+	}
+	if err = res.validate(); err != nil {
+		return nil, err
+	}
+	return &res, scanner.Err()
+}
+
+// make_meta builds metadata of name from rules and .headers file
+func (cmd *ZipCmd) make_meta(name string, input *ChooseFile) *ZipMeta {
+	res := ZipMeta{}
+	for _, rule := range cmd.rules {
+		if ismatch0(name, []string{rule.Pattern}) || ismatch(name, []string{rule.Pattern}) {
+			res.merge(&rule.ZipMeta)
+		}
+	}
+	if cmd.HeadersFile && input.ZipFile == nil {
+		hdrfile := filepath.Join(input.Root, input.Name) + HeadersSuffix
+		if meta, err := ReadHeadersFile(hdrfile); err == nil {
+			res.merge(meta)
+		} else if !os.IsNotExist(err) {
+			slog.Warn("headers file", "name", hdrfile, "error", err)
+		}
+	}
+	if res.empty() {
+		return nil
+	}
+	return &res
+}
+
+// apply_meta adds metadata of entry to fh
+func (cmd *ZipCmd) apply_meta(fh *zip.FileHeader, input *ChooseFile) error {
+	meta := cmd.make_meta(fh.Name, input)
+	if meta == nil {
+		return nil
+	}
+	if input.ZipFile != nil {
+		if orig, err := ParseZipMeta(fh.Extra); err == nil && orig != nil {
+			orig.merge(meta)
+			meta = orig
+		}
+	}
+	slog.Debug("metadata", "name", fh.Name, "meta", meta)
+	return SetZipMeta(fh, meta)
+}
+
+// load_meta reads metadata of each name
+func load_meta(methodmap map[string]map[uint16]int, getidx func(int) *zip.FileHeader) map[string]*ZipMeta {
+	res := make(map[string]*ZipMeta)
+	for name, bymethod := range methodmap {
+		for _, idx := range bymethod {
+			fi := getidx(idx)
+			if fi == nil || len(fi.Extra) == 0 {
+				continue
+			}
+			meta, err := ParseZipMeta(fi.Extra)
+			if err != nil {
+				slog.Warn("invalid metadata", "name", name, "error", err)
+				continue
+			}
+			if meta != nil {
+				res[name] = meta
+				break
+			}
+		}
+	}
+	return res
+}
+
+// apply_meta sets headers by metadata
+func (h *ZipHandler) apply_meta(w http.ResponseWriter, meta *ZipMeta) {
+	for k, v := range meta.Headers {
+		w.Header().Set(k, v)
+	}
+	if meta.CacheControl != "" {
+		w.Header().Set("Cache-Control", meta.CacheControl)
+	}
+	if meta.UseAsDictionary != "" {
+		w.Header().Set("Use-As-Dictionary", `match="`+meta.UseAsDictionary+`"`)
+	}
+}
+
+// redirect_meta responds redirect if metadata of fname has it
+func (h *ZipHandler) redirect_meta(w http.ResponseWriter, fname string, statuscode *int) bool {
+	meta, ok := h.meta[fname]
+	if !ok || meta.Redirect == "" {
+		return false
+	}
+	*statuscode = meta.Status
+	if err := meta.validate(); err != nil {
+		slog.Warn("invalid redirect", "name", fname, "error", err)
+		*statuscode = 0
+	}
+	if *statuscode == 0 {
+		*statuscode = http.StatusMovedPermanently
+	}
+	h.apply_meta(w, meta)
+	w.Header().Set("Location", meta.Redirect)
+	w.WriteHeader(*statuscode)
+	return true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestZipMetaExtra(t *testing.T) {
+	t.Parallel()
+	other := []byte{0x55, 0x54, 0x01, 0x00, 0x00}
+	fh := zip.FileHeader{Name: "test.html", Extra: other}
+	if err := SetZipMeta(&fh, &ZipMeta{CacheControl: "no-cache"}); err != nil {
+		t.Error("set", err)
+	}
+	if err := SetZipMeta(&fh, &ZipMeta{Redirect: "/new.html", Status: 302}); err != nil {
+		t.Error("set(replace)", err)
+	}
+	if !bytes.HasPrefix(fh.Extra, other) {
+		t.Error("other field", fh.Extra)
+	}
+	meta, err := ParseZipMeta(fh.Extra)
+	if err != nil || meta == nil {
+		t.Error("parse", meta, err)
+		return
+	}
+	if meta.Redirect != "/new.html" || meta.Status != 302 || meta.CacheControl != "" {
+		t.Error("meta", meta)
+	}
+	if meta, err := ParseZipMeta(other); meta != nil || err != nil {
+		t.Error("no meta", meta, err)
+	}
+}
+
+func TestReadHeadersFile(t *testing.T) {
+	t.Parallel()
+	fname := filepath.Join(t.TempDir(), "index.html.headers")
+	content := "# comment\nx-frame-options: DENY\nCache-Control: max-age=3600\n\nLocation: /new/\nStatus: 308\n"
+	if err := os.WriteFile(fname, []byte(content), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	meta, err := ReadHeadersFile(fname)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	if meta.Headers["X-Frame-Options"] != "DENY" || len(meta.Headers) != 1 {
+		t.Error("headers", meta.Headers)
+	}
+	if meta.CacheControl != "max-age=3600" || meta.Redirect != "/new/" || meta.Status != 308 {
+		t.Error("meta", meta)
+	}
+	for _, content := range []string{"invalid\n", "Location: /new/\nStatus: 200\n", "Status: 1000\n"} {
+		if err := os.WriteFile(fname, []byte(content), 0o644); err != nil {
+			t.Error("write", err)
+			return
+		}
+		if _, err := ReadHeadersFile(fname); err == nil {
+			t.Error("no error", content)
+		}
+	}
+}
+
+func TestLoadMetaRules(t *testing.T) {
+	t.Parallel()
+	fname := filepath.Join(t.TempDir(), "rules.json")
+	for _, tc := range []struct {
+		content string
+		valid   bool
+	}{
+		{`[{"pattern": "old.html", "redirect": "/new.html", "status": 302}]`, true},
+		{`[{"pattern": "old.html", "redirect": "/new.html"}]`, true},
+		{`[{"pattern": "old.html", "redirect": "/new.html", "status": 99}]`, false},
+		{`[{"pattern": "old.html", "redirect": "/new.html", "status": 404}]`, false},
+	} {
+		if err := os.WriteFile(fname, []byte(tc.content), 0o644); err != nil {
+			t.Error("write", err)
+			return
+		}
+		if _, err := LoadMetaRules(fname); (err == nil) != tc.valid {
+			t.Error("load", tc.content, err)
+		}
+	}
+}
+
+func TestZipMetaServe(t *testing.T) {
+	t.Parallel()
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	for _, ent := range []struct {
+		name string
+		meta *ZipMeta
+	}{
+		{"index.html", &ZipMeta{Headers: map[string]string{"X-Test": "hello"}, CacheControl: "max-age=60"}},
+		{"old.html", &ZipMeta{Redirect: "/index.html"}},
+		{"bad.html", &ZipMeta{Redirect: "/index.html", Status: 1000}},
+		{"plain.html", nil},
+	} {
+		fh := zip.FileHeader{Name: ent.name, Method: zip.Deflate}
+		if ent.meta != nil {
+			if err := SetZipMeta(&fh, ent.meta); err != nil {
+				t.Error("set", err)
+			}
+		}
+		ofp, err := wr.CreateHeader(&fh)
+		if err != nil {
+			t.Error("create", err)
+			return
+		}
+		if _, err = ofp.Write([]byte(ent.name)); err != nil {
+			t.Error("write", err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Error("close", err)
+	}
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+	}
+	if err := hdl.initialize_memory([][]byte{buf.Bytes()}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	for _, tc := range []struct {
+		path     string
+		encoding string
+		status   int
+		header   string
+		value    string
+	}{
+		{"/", "", http.StatusOK, "X-Test", "hello"},
+		{"/", "gzip", http.StatusOK, "Cache-Control", "max-age=60"},
+		{"/old.html", "", http.StatusMovedPermanently, "Location", "/index.html"},
+		{"/bad.html", "", http.StatusMovedPermanently, "Location", "/index.html"},
+		{"/plain.html", "", http.StatusOK, "Cache-Control", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", tc.encoding)
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if got.Code != tc.status {
+			t.Error("status", tc.path, got.Code)
+		}
+		if val := got.Result().Header.Get(tc.header); val != tc.value {
+			t.Error("header", tc.path, tc.header, val)
+		}
+	}
+}
+
+func TestZipCmdMeta(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	base := t.TempDir()
+	srcdir := filepath.Join(base, "src")
+	if err := os.Mkdir(srcdir, 0o755); err != nil {
+		t.Error("mkdir", err)
+		return
+	}
+	for name, content := range map[string]string{
+		"index.html":         "<html></html>",
+		"index.html.headers": "X-Test: hello\n",
+		"style.css":          "body {}",
+	} {
+		if err := os.WriteFile(filepath.Join(srcdir, name), []byte(content), 0o644); err != nil {
+			t.Error("write", err)
+			return
+		}
+	}
+	rules := filepath.Join(base, "rules.json")
+	if err := os.WriteFile(rules, []byte(`[{"pattern":"*.css","cache-control":"max-age=86400"},{"pattern":"index.html","headers":{"X-Rule":"1"}}]`), 0o644); err != nil {
+		t.Error("write rules", err)
+		return
+	}
+	output := filepath.Join(base, "output.zip")
+	zz := ZipCmd{
+		StripRoot:   true,
+		MinSize:     512,
+		Method:      "deflate",
+		MetaRules:   rules,
+		HeadersFile: true,
+	}
+	globalOption.Archive = flags.Filename(output)
+	if err := zz.Execute([]string{srcdir}); err != nil {
+		t.Error("failed", err)
+		return
+	}
+	zipcmd_helper_check(t, output, []string{"index.html", "style.css"})
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer zr.Close()
+	for _, fi := range zr.File {
+		meta, err := ParseZipMeta(fi.Extra)
+		if err != nil || meta == nil {
+			t.Error("meta", fi.Name, meta, err)
+			continue
+		}
+		switch fi.Name {
+		case "index.html":
+			if meta.Headers["X-Test"] != "hello" || meta.Headers["X-Rule"] != "1" {
+				t.Error("index.html", meta)
+			}
+		case "style.css":
+			if meta.CacheControl != "max-age=86400" {
+				t.Error("style.css", meta)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+type MmapOption struct {
+	Advise  string
+	Prewarm bool
+}
+
+type ZipFileMmap struct {
+	z       *zip.Reader
+	mapping []byte
+	name    string
+	lock    sync.Mutex
+	refs    int
+	closing bool
+}
+
+func (z *ZipFileMmap) Open(name string) (fs.File, error) {
+	return z.z.Open(name)
+}
+
+func (z *ZipFileMmap) File(idx int) *zip.File {
+	return z.z.File[idx]
+}
+
+func (z *ZipFileMmap) Files() int {
+	return len(z.z.File)
+}
+
+func (z *ZipFileMmap) Name() string {
+	return z.name
+}
+
+// acquire keeps the mapping alive until the matching release
+func (z *ZipFileMmap) acquire() {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.refs++
+}
+
+func (z *ZipFileMmap) release() {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.refs--
+	if z.refs == 0 && z.closing {
+		z.unmap()
+	}
+}
+
+// Close unmaps the archive now, or after the last in-flight user released it
+func (z *ZipFileMmap) Close() error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.closing = true
+	if z.refs == 0 {
+		return z.unmap()
+	}
+	slog.Debug("unmap deferred", "name", z.name, "refs", z.refs)
+	return nil
+}
+
+func (z *ZipFileMmap) unmap() error {
+	if z.mapping == nil {
+		return nil
+	}
+	err := munmap_file(z.mapping)
+	if err != nil {
+		slog.Error("munmap", "name", z.name, "error", err)
+	}
+	z.mapping = nil
+	slog.Debug("unmapped", "name", z.name)
+	return err
+}
+
+func (z *ZipFileMmap) prewarm(data []byte) {
+	defer z.release()
+	pagesize := os.Getpagesize()
+	var sum byte
+	for i := 0; i < len(data); i += pagesize {
+		sum += data[i]
+	}
+	slog.Debug("prewarm done", "name", z.name, "size", len(data), "sum", sum)
+}
+
+func NewZipFileMmap(name string, opt MmapOption) (*ZipFileMmap, error) {
+	offs, err := ArchiveOffset(name)
+	if err != nil {
+		slog.Error("archiveoffset", "file", name, "error", err)
+		return nil, err
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		slog.Error("open file to mmap", "file", name, "error", err)
+		return nil, err
+	}
+	defer fp.Close()
+	st, err := fp.Stat()
+	if err != nil {
+		slog.Error("stat", "file", name, "error", err)
+		return nil, err
+	}
+	mapping, data, err := mmap_file(fp, offs, st.Size()-offs)
+	if err != nil {
+		return nil, err
+	}
+	if err = madvise_file(mapping, opt.Advise); err != nil {
+		slog.Warn("madvise", "file", name, "advise", opt.Advise, "error", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		if err := munmap_file(mapping); err != nil {
+			slog.Error("munmap", "file", name, "error", err)
+		}
+		return nil, err
+	}
+	res := ZipFileMmap{z: z, mapping: mapping, name: name}
+	slog.Debug("mmap size", "file", name, "offset", offs, "size", len(data))
+	if opt.Prewarm {
+		res.acquire()
+		go res.prewarm(data)
+	}
+	return &res, nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func mmap_file(fp *os.File, offset int64, size int64) ([]byte, []byte, error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+func munmap_file(mapping []byte) error {
+	return errors.ErrUnsupported
+}
+
+func madvise_file(mapping []byte, advise string) error {
+	return errors.ErrUnsupported
+}
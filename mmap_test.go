@@ -0,0 +1,136 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestZipFileMmap(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	zf, err := NewZipFileMmap(name, MmapOption{Advise: "random", Prewarm: true})
+	if err != nil {
+		t.Error("new zip mmap", err)
+		return
+	}
+	if zf.Files() == 0 {
+		t.Error("empty files")
+		return
+	}
+	fi := zf.File(0)
+	if fi == nil {
+		t.Error("nil file")
+		return
+	}
+	rd, err := zf.Open(fi.Name)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	_ = rd.Close()
+	if err = zf.Close(); err != nil {
+		t.Error("close", err)
+	}
+}
+
+func TestZipFileMmapSelf(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	// prepend dummy executable of not page-aligned size
+	selfname := name + ".self"
+	if err = os.WriteFile(selfname, append(bytes.Repeat([]byte{0x7f}, 5000), data...), 0o600); err != nil {
+		t.Error("write", err)
+		return
+	}
+	zf, err := NewZipFileMmap(selfname, MmapOption{})
+	if err != nil {
+		t.Error("new zip mmap", err)
+		return
+	}
+	defer zf.Close()
+	if zf.Files() != 4 {
+		t.Error("files", zf.Files())
+	}
+}
+
+func TestZipFileMmapDeferredUnmap(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	zf, err := NewZipFileMmap(name, MmapOption{})
+	if err != nil {
+		t.Error("new zip mmap", err)
+		return
+	}
+	zf.acquire()
+	if err = zf.Close(); err != nil {
+		t.Error("close", err)
+	}
+	if zf.mapping == nil {
+		t.Error("unmapped while in use")
+	}
+	zf.release()
+	if zf.mapping != nil {
+		t.Error("not unmapped after release")
+	}
+}
+
+func TestZipFileMmapInvalidAdvise(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	zf, err := NewZipFileMmap(name, MmapOption{Advise: "invalid"})
+	if err != nil {
+		t.Error("new zip mmap", err)
+		return
+	}
+	if err = zf.Close(); err != nil {
+		t.Error("close", err)
+	}
+}
+
+func TestInitializeMmapAndServe(t *testing.T) {
+	t.Parallel()
+	zipname := prepare_testzip(t)
+	h := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		mmapopt:   &MmapOption{Advise: "willneed"},
+	}
+	if err := h.initialize([]string{zipname}, false); err != nil {
+		t.Error("initialize mmap", err)
+		return
+	}
+	defer h.Close()
+	if _, ok := h.zipfiles[0].(*ZipFileMmap); !ok {
+		t.Errorf("not mmap: %T", h.zipfiles[0])
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt", bytes.NewBuffer([]byte{}))
+	req.Header.Set("Accept-Encoding", "gzip")
+	got := httptest.NewRecorder()
+	h.ServeHTTP(got, req)
+	if got.Code != http.StatusOK {
+		t.Error("status", got.Code)
+	}
+	if enc := got.Result().Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Error("content-encoding", enc)
+	}
+	// reload to new generation
+	if err := h.initialize([]string{zipname}, false); err != nil {
+		t.Error("reload mmap", err)
+	}
+	req2 := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/512b.txt", bytes.NewBuffer([]byte{}))
+	got2 := httptest.NewRecorder()
+	h.ServeHTTP(got2, req2)
+	if got2.Body.Len() != 512 {
+		t.Error("body", got2.Body.Len())
+	}
+}
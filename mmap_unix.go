@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmap_file maps [offset, offset+size) read-only; offset need not be page-aligned
+func mmap_file(fp *os.File, offset int64, size int64) (mapping []byte, data []byte, err error) {
+	pagesize := int64(os.Getpagesize())
+	aligned := offset - offset%pagesize
+	mapping, err = unix.Mmap(int(fp.Fd()), aligned, int(size+offset-aligned), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapping, mapping[offset-aligned:], nil
+}
+
+func munmap_file(mapping []byte) error {
+	return unix.Munmap(mapping)
+}
+
+func madvise_file(mapping []byte, advise string) error {
+	switch advise {
+	case "", "normal":
+		return unix.Madvise(mapping, unix.MADV_NORMAL)
+	case "random":
+		return unix.Madvise(mapping, unix.MADV_RANDOM)
+	case "sequential":
+		return unix.Madvise(mapping, unix.MADV_SEQUENTIAL)
+	case "willneed":
+		return unix.Madvise(mapping, unix.MADV_WILLNEED)
+	}
+	return fmt.Errorf("unknown advise: %s", advise)
+}
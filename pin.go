@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+type PinOption struct {
+	Budget   int64
+	MaxSize  uint64
+	Patterns []string
+	Hits     uint32
+}
+
+// ZipFileHybrid keeps raw data of some entries in memory, others are read from base
+type ZipFileHybrid struct {
+	base   ZipFile
+	opt    PinOption
+	pinned []atomic.Pointer[zip.File]
+	hits   []atomic.Uint32
+	used   atomic.Int64
+	lock   sync.Mutex
+	closed bool
+}
+
+func (z *ZipFileHybrid) Open(name string) (fs.File, error) {
+	return z.base.Open(name)
+}
+
+func (z *ZipFileHybrid) File(idx int) *zip.File {
+	if fi := z.pinned[idx].Load(); fi != nil {
+		return fi
+	}
+	return z.base.File(idx)
+}
+
+func (z *ZipFileHybrid) FileHeader(idx int) *zip.FileHeader {
+	if fi := z.pinned[idx].Load(); fi != nil {
+		return &fi.FileHeader
+	}
+	return zip_header(z.base, idx)
+}
+
+func (z *ZipFileHybrid) Index() []ZipIndexEntry {
+	if indexed, ok := z.base.(indexedZipFile); ok {
+		return indexed.Index()
+	}
+	return nil
+}
+
+func (z *ZipFileHybrid) Files() int {
+	return z.base.Files()
+}
+
+func (z *ZipFileHybrid) Name() string {
+	if named, ok := z.base.(namedZipFile); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+func (z *ZipFileHybrid) Close() error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.closed = true
+	return z.base.Close()
+}
+
+func (z *ZipFileHybrid) acquire() {
+	if rc, ok := z.base.(refCounted); ok {
+		rc.acquire()
+	}
+}
+
+func (z *ZipFileHybrid) release() {
+	if rc, ok := z.base.(refCounted); ok {
+		rc.release()
+	}
+}
+
+func (z *ZipFileHybrid) OpenSection(idx int, fi *zip.File) (io.ReadCloser, error) {
+	if z.pinned[idx].Load() == nil {
+		if so, ok := z.base.(sectionOpener); ok {
+			return so.OpenSection(idx, fi)
+		}
+	}
+	rd, err := fi.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(rd), nil
+}
+
+// hit counts access to idx and pins it in background when it gets hot
+func (z *ZipFileHybrid) hit(idx int) {
+	if z.opt.Hits == 0 || z.pinned[idx].Load() != nil {
+		return
+	}
+	if z.hits[idx].Add(1) == z.opt.Hits {
+		go func() {
+			z.lock.Lock()
+			defer z.lock.Unlock()
+			if z.closed {
+				return
+			}
+			z.pin(idx)
+		}()
+	}
+}
+
+// pin copies raw data of idx into memory. caller must hold lock
+func (z *ZipFileHybrid) pin(idx int) bool {
+	fi := z.base.File(idx)
+	if z.used.Load()+int64(fi.CompressedSize64) > z.opt.Budget {
+		slog.Debug("pin budget exceeded", "name", fi.Name, "size", fi.CompressedSize64, "used", z.used.Load())
+		return false
+	}
+	pinned, err := pin_file(fi)
+	if err != nil {
+		slog.Warn("pin failed", "name", fi.Name, "error", err)
+		return false
+	}
+	z.used.Add(int64(fi.CompressedSize64))
+	z.pinned[idx].Store(pinned)
+	slog.Debug("pinned", "name", fi.Name, "method", fi.Method, "size", fi.CompressedSize64)
+	return true
+}
+
+// pin_file makes single entry zip in memory to keep *zip.File interface
+func pin_file(fi *zip.File) (*zip.File, error) {
+	rd, err := fi.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	fh := fi.FileHeader
+	ofp, err := wr.CreateRaw(&fh)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(ofp, rd); err != nil {
+		return nil, err
+	}
+	if err = wr.Close(); err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return nil, err
+	}
+	return zr.File[0], nil
+}
+
+func NewZipFileHybrid(base ZipFile, opt PinOption) *ZipFileHybrid {
+	res := &ZipFileHybrid{
+		base:   base,
+		opt:    opt,
+		pinned: make([]atomic.Pointer[zip.File], base.Files()),
+		hits:   make([]atomic.Uint32, base.Files()),
+	}
+	res.lock.Lock()
+	defer res.lock.Unlock()
+	for i := range base.Files() {
+		fi := zip_header(base, i)
+		if fi == nil || fi.FileInfo().IsDir() {
+			continue
+		}
+		if fi.CompressedSize64 <= opt.MaxSize || ismatch(fi.Name, opt.Patterns) {
+			res.pin(i)
+		}
+	}
+	slog.Info("pinned entries", "name", res.Name(), "used", res.used.Load(), "budget", opt.Budget)
+	return res
+}
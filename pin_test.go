@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestZipFileHybridPinBySize(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	base, err := NewZipFileFile(name)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	zf := NewZipFileHybrid(base, PinOption{Budget: 1 << 20, MaxSize: 1024})
+	defer zf.Close()
+	// 4kb.txt(20 bytes), 512b.txt(512 bytes)
+	if zf.used.Load() != 532 {
+		t.Error("used", zf.used.Load())
+	}
+	for i := range zf.Files() {
+		fi := zf.File(i)
+		pinned := zf.pinned[i].Load() != nil
+		if pinned != (fi.CompressedSize64 <= 1024) {
+			t.Error("pinned", fi.Name, pinned)
+		}
+		orig := base.File(i)
+		if fi.Name != orig.Name || fi.CRC32 != orig.CRC32 || fi.Method != orig.Method || !fi.Modified.Equal(orig.Modified) {
+			t.Error("header mismatch", fi.Name)
+		}
+		rd, err := zf.OpenSection(i, fi)
+		if err != nil {
+			t.Error("open section", fi.Name, err)
+			continue
+		}
+		data, err := io.ReadAll(rd)
+		_ = rd.Close()
+		if err != nil || uint64(len(data)) != fi.CompressedSize64 {
+			t.Error("read", fi.Name, len(data), err)
+		}
+	}
+}
+
+func TestZipFileHybridPinByPatternAndBudget(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	base, err := NewZipFileFile(name)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	zf := NewZipFileHybrid(base, PinOption{Budget: 2000, Patterns: []string{"*mb.txt"}})
+	defer zf.Close()
+	// 1mb.txt(1033 bytes) fits, 128mb.txt(130260 bytes) does not
+	if zf.used.Load() != 1033 {
+		t.Error("used", zf.used.Load())
+	}
+}
+
+func TestZipFileHybridPinByHits(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	h := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		pinopt:    &PinOption{Budget: 1 << 20, Hits: 2},
+	}
+	if err := h.initialize_file([]string{name}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	defer h.Close()
+	zf, ok := h.zipfiles[0].(*ZipFileHybrid)
+	if !ok {
+		t.Errorf("not hybrid: %T", h.zipfiles[0])
+		return
+	}
+	if zf.used.Load() != 0 {
+		t.Error("pinned at load", zf.used.Load())
+	}
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt", bytes.NewBuffer([]byte{}))
+		req.Header.Set("Accept-Encoding", "gzip")
+		got := httptest.NewRecorder()
+		h.ServeHTTP(got, req)
+		if got.Code != http.StatusOK {
+			t.Error("status", got.Code)
+		}
+		if enc := got.Result().Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Error("content-encoding", enc)
+		}
+	}
+	for range 100 {
+		if zf.used.Load() != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if zf.used.Load() != 20 {
+		t.Error("not pinned by hits", zf.used.Load())
+	}
+}
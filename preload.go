@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+func attr(node *html.Node, key string) (string, bool) {
+	for _, v := range node.Attr {
+		if v.Key == key {
+			return v.Val, true
+		}
+	}
+	return "", false
+}
+
+// preload_link makes Link header value of href. relative links are kept as is, because Link header is resolved against the document URL. external links are ignored
+func preload_link(href string, as string) string {
+	if href == "" || strings.HasPrefix(href, "//") || strings.Contains(href, ":") {
+		return ""
+	}
+	return "<" + href + ">; rel=preload; as=" + as
+}
+
+// preload_of returns preload link of css and js in head
+func preload_of(c *html.Node) string {
+	if c.Parent == nil || c.Parent.Data != "head" {
+		return ""
+	}
+	var link string
+	switch c.Data {
+	case "link":
+		rel, _ := attr(c, "rel")
+		href, _ := attr(c, "href")
+		switch strings.ToLower(rel) {
+		case "stylesheet":
+			link = preload_link(href, "style")
+		case "preload":
+			if as, ok := attr(c, "as"); ok {
+				link = preload_link(href, as)
+			}
+		}
+	case "script":
+		if _, ok := attr(c, "async"); ok {
+			return ""
+		}
+		src, _ := attr(c, "src")
+		link = preload_link(src, "script")
+		if typ, _ := attr(c, "type"); link != "" && typ == "module" {
+			link = strings.Replace(link, "rel=preload; as=script", "rel=modulepreload", 1)
+		}
+	}
+	return link
+}
+
+func is_html(name string) bool {
+	return ismatch(strings.ToLower(name), []string{"*.html", "*.htm"})
+}
+
+// preload_copy copies html as is, and collects preload links while it is parsed
+func preload_copy(dst io.Writer, src io.Reader, preload *[]string) (int64, error) {
+	cw := &countWriter{Writer: dst}
+	tee := io.TeeReader(src, cw)
+	node, err := html.Parse(tee)
+	if err != nil {
+		return cw.written, err
+	}
+	if _, err = io.Copy(io.Discard, tee); err != nil {
+		return cw.written, err
+	}
+	return cw.written, dochild(node, "", preload)
+}
+
+// countWriter counts written bytes
+type countWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// set_preload stores preload links of html collected on compression to meta.
+// html copied as is was not parsed, so it is parsed here
+func (cmd *ZipCmd) set_preload(f *zip.File, meta *ZipMeta) error {
+	if !is_html(f.Name) {
+		return nil
+	}
+	links, ok := cmd.preloads[f.Name]
+	if !ok {
+		rd, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+		links = new([]string)
+		if _, err = preload_copy(io.Discard, rd, links); err != nil {
+			slog.Error("parse", "name", f.Name, "error", err)
+			return err
+		}
+	}
+	slog.Debug("preload", "name", f.Name, "links", *links)
+	meta.Preload = *links
+	return nil
+}
+
+// send_hints adds Link header, and sends 103 Early Hints if enabled
+func (h *ZipHandler) send_hints(w http.ResponseWriter, r *http.Request, fname string) {
+	meta, ok := h.meta[fname]
+	if !ok || len(meta.Preload) == 0 {
+		return
+	}
+	for _, link := range meta.Preload {
+		w.Header().Add("Link", link)
+	}
+	if h.earlyhints && r.ProtoAtLeast(1, 1) {
+		slog.Debug("early hints", "name", fname, "links", len(meta.Preload))
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
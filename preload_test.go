@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/net/html"
+)
+
+const preload_html = `<html><head>
+<link rel="stylesheet" href="style.css">
+<link rel="preload" href="/font.woff2" as="font">
+<link rel="icon" href="favicon.ico">
+<link rel="stylesheet" href="https://cdn.example.com/ext.css">
+<script src="../js/app.js"></script>
+<script src="analytics.js" async></script>
+<script type="module" src="main.mjs"></script>
+</head><body><script src="body.js"></script></body></html>`
+
+func TestExtractPreload(t *testing.T) {
+	t.Parallel()
+	node, err := html.Parse(strings.NewReader(preload_html))
+	if err != nil {
+		t.Error("parse", err)
+		return
+	}
+	expected := []string{
+		"<style.css>; rel=preload; as=style",
+		"</font.woff2>; rel=preload; as=font",
+		"<../js/app.js>; rel=preload; as=script",
+		"<main.mjs>; rel=modulepreload",
+	}
+	res := make([]string, 0)
+	if err = dochild(node, "", &res); err != nil || !slices.Equal(res, expected) {
+		t.Error("preload", res, err)
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	t.Parallel()
+	links := []string{"</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script"}
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	fh := zip.FileHeader{Name: "index.html", Method: zip.Deflate}
+	if err := SetZipMeta(&fh, &ZipMeta{Preload: links}); err != nil {
+		t.Error("meta", err)
+	}
+	ofp, err := wr.CreateHeader(&fh)
+	if err != nil {
+		t.Error("create", err)
+		return
+	}
+	if _, err = ofp.Write([]byte(preload_html)); err != nil {
+		t.Error("write", err)
+	}
+	if err = wr.Close(); err != nil {
+		t.Error("close", err)
+	}
+	for _, earlyhints := range []bool{true, false} {
+		hdl := ZipHandler{
+			indexname:  "index.html",
+			methodmap:  make(map[string]map[uint16]int),
+			earlyhints: earlyhints,
+		}
+		if err := hdl.initialize_memory([][]byte{buf.Bytes()}); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+		srv := httptest.NewServer(&hdl)
+		hints := make([]string, 0)
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					hints = append(hints, header.Values("Link")...)
+				}
+				return nil
+			},
+		}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, srv.URL+"/", nil)
+		if err != nil {
+			t.Error("request", err)
+			srv.Close()
+			return
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error("do", err)
+			srv.Close()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		hints304 := len(hints)
+		req.Header.Set("If-None-Match", resp.Header.Get("Etag"))
+		if resp304, err := http.DefaultClient.Do(req); err != nil {
+			t.Error("do", err)
+		} else {
+			resp304.Body.Close()
+			if resp304.StatusCode != http.StatusNotModified || len(hints) != hints304 {
+				t.Error("early hints before 304", resp304.StatusCode, hints)
+			}
+		}
+		srv.Close()
+		if string(body) != preload_html {
+			t.Error("body", len(body))
+		}
+		if !slices.Equal(resp.Header.Values("Link"), links) {
+			t.Error("link", resp.Header.Values("Link"))
+		}
+		if earlyhints && !slices.Equal(hints, links) {
+			t.Error("early hints", hints)
+		}
+		if !earlyhints && len(hints) != 0 {
+			t.Error("unexpected early hints", hints)
+		}
+	}
+}
+
+func TestZipCmdPreload(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "index.html"), []byte(preload_html), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(base, "style.css"), []byte("body {}"), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	for _, baseurl := range []string{"", "http://example.com/"} {
+		output := filepath.Join(t.TempDir(), "output.zip")
+		zz := ZipCmd{
+			StripRoot: true,
+			MinSize:   512,
+			Method:    "deflate",
+			Preload:   true,
+			SortBy:    "name",
+			BaseURL:   baseurl,
+		}
+		globalOption.Archive = flags.Filename(output)
+		if err := zz.Execute([]string{base}); err != nil {
+			t.Error("failed", err)
+			return
+		}
+		zr, err := zip.OpenReader(output)
+		if err != nil {
+			t.Error("open", err)
+			return
+		}
+		for _, fi := range zr.File {
+			meta, err := ParseZipMeta(fi.Extra)
+			if err != nil {
+				t.Error("meta", fi.Name, err)
+				continue
+			}
+			switch fi.Name {
+			case "index.html":
+				if meta == nil || len(meta.Preload) != 4 {
+					t.Error("index.html", baseurl, meta)
+				}
+				rd, err := fi.Open()
+				if err != nil {
+					t.Error("open", err)
+					continue
+				}
+				body, err := io.ReadAll(rd)
+				rd.Close()
+				if err != nil || (baseurl == "" && string(body) != preload_html) {
+					t.Error("content is changed", err, string(body))
+				}
+			case "style.css":
+				if meta != nil {
+					t.Error("style.css", meta)
+				}
+			}
+		}
+		zr.Close()
+	}
+}
+
+func TestZipCmdPreloadError(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	base := t.TempDir()
+	// preload links exceed size of extra field
+	var buf strings.Builder
+	buf.WriteString("<html><head>")
+	for i := range 2000 {
+		fmt.Fprintf(&buf, `<link rel="stylesheet" href="/css/%040d.css">`, i)
+	}
+	buf.WriteString("</head></html>")
+	if err := os.WriteFile(filepath.Join(base, "index.html"), []byte(buf.String()), 0o644); err != nil {
+		t.Error("write", err)
+		return
+	}
+	output := filepath.Join(t.TempDir(), "output.zip")
+	zz := ZipCmd{
+		StripRoot: true,
+		MinSize:   512,
+		Method:    "deflate",
+		Preload:   true,
+		Sidecar:   true,
+	}
+	globalOption.Archive = flags.Filename(output)
+	if err := zz.Execute([]string{base}); err == nil {
+		t.Error("no error")
+	}
+	if _, err := os.Stat(sidecarFilename(output)); !os.IsNotExist(err) {
+		t.Error("sidecar written", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// resolverFiles are read by net package to resolve names
+var resolverFiles = []string{"/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf"}
+
+// PrivOption is applied after listeners are opened
+type PrivOption struct {
+	User       string
+	Group      string
+	Chroot     string
+	Landlock   bool
+	ReadPaths  []string
+	WritePaths []string
+}
+
+func (opt *PrivOption) enabled() bool {
+	return opt.User != "" || opt.Group != "" || opt.Chroot != "" || opt.Landlock
+}
+
+// sandbox_paths returns paths to allow in sandbox. archives are not needed if in-memory
+func (cmd *WebServer) sandbox_paths(archives []string) ([]string, []string) {
+	ro := append([]string{}, cmd.LandlockRead...)
+	rw := append([]string{}, cmd.LandlockWrite...)
+	if !cmd.InMemory {
+		// directory is allowed to read archive replaced by rename
+		for _, name := range archives {
+			if abs, err := filepath.Abs(name); err == nil {
+				ro = append(ro, filepath.Dir(abs))
+			}
+		}
+	}
+	if cmd.AccessLog != "" && cmd.handler.access != nil {
+		if _, ok := cmd.handler.access.out.(*logFile); ok {
+			rw = append(rw, filepath.Dir(cmd.AccessLog))
+		}
+	}
+	// config and acl files are read again by SIGHUP
+	for _, name := range []string{string(globalOption.Config), string(cmd.ACLFile)} {
+		if name == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(name); err == nil {
+			ro = append(ro, abs)
+		}
+	}
+	// resolver of upstream and exporter reads them when changed
+	for _, name := range resolverFiles {
+		if _, err := os.Stat(name); err == nil {
+			ro = append(ro, name)
+		}
+	}
+	if cmd.TranscodeDir != "" {
+		rw = append(rw, cmd.TranscodeDir)
+	}
+	return ro, rw
+}
+
+// preload_sandbox reads files lazily loaded by standard library before they are denied
+func preload_sandbox() {
+	mime.TypeByExtension(".html")
+	// system CA bundle is cached on first use
+	if _, err := x509.SystemCertPool(); err != nil {
+		slog.Warn("system cert pool", "error", err)
+	}
+}
+
+// chroot_path returns name seen from inside of root. relative name is resolved from workdir
+func chroot_path(root string, workdir string, name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(workdir, name)
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of chroot %s", name, root)
+	}
+	return filepath.Join("/", rel), nil
+}
+
+// enter_chroot translates names opened again after chroot. archives outside of root cannot be reloaded
+func (cmd *WebServer) enter_chroot(root string, workdir string, archives []string) {
+	cmd.chrooted = true
+	cmd.archives = make([]string, 0, len(archives))
+	for _, name := range archives {
+		inner, err := chroot_path(root, workdir, name)
+		if err != nil {
+			slog.Warn("reload is disabled", "error", err)
+			cmd.archives = nil
+			break
+		}
+		cmd.archives = append(cmd.archives, inner)
+	}
+	translate := func(name *string) {
+		if *name == "" {
+			return
+		}
+		inner, err := chroot_path(root, workdir, *name)
+		if err != nil {
+			slog.Warn("cannot be reopened", "error", err)
+			return
+		}
+		*name = inner
+	}
+	config := string(globalOption.Config)
+	translate(&config)
+	globalOption.Config = flags.Filename(config)
+	if cmd.handler.acl != nil {
+		translate(&cmd.handler.acl.filename)
+	}
+	if cmd.handler.access != nil {
+		if lf, ok := cmd.handler.access.out.(*logFile); ok {
+			lf.lock.Lock()
+			translate(&lf.name)
+			lf.lock.Unlock()
+		}
+	}
+	if cmd.handler.transcode != nil && cmd.handler.transcode.CacheDir != "" {
+		translate(&cmd.handler.transcode.CacheDir)
+		// running transcoder writes cache by name before chroot
+		if err := cmd.Reload(); err != nil {
+			slog.Warn("restart transcode", "error", err)
+		}
+	}
+}
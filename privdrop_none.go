@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func drop_privileges(opt PrivOption) error {
+	return errors.ErrUnsupported
+}
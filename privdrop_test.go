@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestSandboxPaths(t *testing.T) {
+	t.Parallel()
+	cmd := WebServer{LandlockRead: []string{"/etc/ssl"}, TranscodeDir: "/var/cache/ziphttp", ACLFile: "/etc/ziphttp/acl.json"}
+	ro, rw := cmd.sandbox_paths([]string{"/srv/site/site.zip"})
+	if len(ro) < 3 || ro[1] != "/srv/site" || ro[2] != "/etc/ziphttp/acl.json" || len(rw) != 1 || rw[0] != "/var/cache/ziphttp" {
+		t.Error("paths", ro, rw)
+	}
+	for _, name := range resolverFiles {
+		if _, err := os.Stat(name); err == nil && !slices.Contains(ro, name) {
+			t.Error("resolver", name, ro)
+		}
+	}
+	cmd.InMemory = true
+	if ro2, _ := cmd.sandbox_paths([]string{"/srv/site/site.zip"}); len(ro2) != len(ro)-1 {
+		t.Error("in-memory", ro2)
+	}
+}
+
+func TestChrootPath(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"/srv/site/site.zip", "/site/site.zip"},
+		{"site.zip", "/site/site.zip"},
+		{"../data/a.zip", "/data/a.zip"},
+		{"/srv", "/"},
+		{"/etc/passwd", ""},
+		{"/srvx/a.zip", ""},
+	} {
+		res, err := chroot_path("/srv", "/srv/site", tc.name)
+		if res != tc.expected || (err == nil) != (tc.expected != "") {
+			t.Error("chroot path", tc.name, res, err)
+		}
+	}
+}
+
+func TestEnterChroot(t *testing.T) {
+	oldConfig := globalOption.Config
+	defer func() {
+		globalOption.Config = oldConfig
+	}()
+	root := t.TempDir()
+	globalOption.Config = flags.Filename(filepath.Join(root, "etc", "ziphttp.yaml"))
+	cmd := WebServer{}
+	cmd.handler.acl = &ACL{filename: filepath.Join(root, "etc", "acl.json")}
+	cmd.enter_chroot(root, filepath.Join(root, "srv"), []string{"site.zip", filepath.Join(root, "alt.zip")})
+	if !cmd.chrooted || !slices.Equal(cmd.archives, []string{"/srv/site.zip", "/alt.zip"}) {
+		t.Error("archives", cmd.archives)
+	}
+	if globalOption.Config != "/etc/ziphttp.yaml" || cmd.handler.acl.filename != "/etc/acl.json" {
+		t.Error("files", globalOption.Config, cmd.handler.acl.filename)
+	}
+	cmd = WebServer{}
+	cmd.enter_chroot(root, root, []string{"/outside/site.zip"})
+	if err := cmd.Reload(); err == nil {
+		t.Error("reload outside of chroot")
+	}
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// switch_user changes group and user. supplementary groups are cleared
+func switch_user(uid int, gid int) error {
+	var err error
+	if gid != -1 {
+		if err = syscall.Setgroups([]int{gid}); err != nil {
+			return fmt.Errorf("setgroups: %w", err)
+		}
+		if err = syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %w", gid, err)
+		}
+	}
+	if uid != -1 {
+		if err = syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %w", uid, err)
+		}
+	}
+	slog.Info("switch user", "uid", strconv.Itoa(os.Getuid()), "gid", strconv.Itoa(os.Getgid()))
+	return nil
+}
+
+// drop_privileges applies chroot, user and landlock in this order
+func drop_privileges(opt PrivOption) error {
+	uid, gid := -1, -1
+	if opt.User != "" || opt.Group != "" {
+		var err error
+		// users are looked up before chroot
+		if uid, gid, err = lookup_owner(opt.User + ":" + opt.Group); err != nil {
+			return err
+		}
+	}
+	ruleset := -1
+	if opt.Landlock {
+		var err error
+		// paths are resolved before chroot
+		if ruleset, err = landlock_ruleset(opt.ReadPaths, opt.WritePaths); err != nil {
+			return err
+		}
+	}
+	if opt.Landlock || opt.Chroot != "" {
+		preload_sandbox()
+	}
+	if opt.Chroot != "" {
+		if err := syscall.Chroot(opt.Chroot); err != nil {
+			return fmt.Errorf("chroot %s: %w", opt.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return err
+		}
+		slog.Info("chroot", "dir", opt.Chroot)
+	}
+	if uid != -1 || gid != -1 {
+		if err := switch_user(uid, gid); err != nil {
+			return err
+		}
+	}
+	if ruleset != -1 {
+		return landlock_restrict(ruleset)
+	}
+	return nil
+}
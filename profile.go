@@ -2,4 +2,13 @@
 
 package main
 
-import _ "net/http/pprof"
+import (
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// public_handler serves pprof and expvar of http.DefaultServeMux with handler
+func public_handler(handler http.Handler) http.Handler {
+	http.Handle("/", handler)
+	return http.DefaultServeMux
+}
@@ -0,0 +1,12 @@
+//go:build !profile
+
+package main
+
+import "net/http"
+
+// public_handler serves handler behind ServeMux to clean request path. expvar is served by admin server
+func public_handler(handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	return mux
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyOption is list of trusted reverse proxies
+type ProxyOption struct {
+	Networks []*net.IPNet
+}
+
+type clientKey struct{}
+
+// clientInfo is address of peer before resolved, and scheme of client
+type clientInfo struct {
+	Peer   string
+	Scheme string
+}
+
+type forwardedHop struct {
+	addr  string
+	proto string
+}
+
+// parse_forwarded parses Forwarded header (RFC 7239) into hops, client first
+func parse_forwarded(values []string) []forwardedHop {
+	res := make([]forwardedHop, 0)
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(strings.TrimSpace(v), `"`)
+				switch strings.ToLower(strings.TrimSpace(k)) {
+				case "for":
+					hop.addr = v
+				case "proto":
+					hop.proto = strings.ToLower(v)
+				}
+			}
+			res = append(res, hop)
+		}
+	}
+	return res
+}
+
+// parse_xforwarded parses X-Forwarded-For and X-Forwarded-Proto into hops, client first
+func parse_xforwarded(fors []string, protos []string) []forwardedHop {
+	res := make([]forwardedHop, 0)
+	for _, value := range fors {
+		for _, addr := range strings.Split(value, ",") {
+			res = append(res, forwardedHop{addr: strings.TrimSpace(addr)})
+		}
+	}
+	if len(res) != 0 && len(protos) != 0 {
+		proto, _, _ := strings.Cut(protos[0], ",")
+		res[0].proto = strings.ToLower(strings.TrimSpace(proto))
+	}
+	return res
+}
+
+// hop_addr returns host and port of hop. "[2001:db8::1]:80", "192.0.2.1:80" and "192.0.2.1" are accepted
+func hop_addr(addr string) (net.IP, string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), "0"
+	}
+	return net.ParseIP(host), port
+}
+
+// resolve returns address and scheme of client from hops, excluding trusted proxies.
+// the first untrusted hop is the client. unparsable hop such as "unknown" or obfuscated identifier is also the client
+func (opt *ProxyOption) resolve(hops []forwardedHop) (string, string) {
+	addr, proto := "", ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, port := hop_addr(hops[i].addr)
+		if ip == nil {
+			addr, proto = hops[i].addr, hops[i].proto
+			if addr == "" {
+				addr = "unknown"
+			}
+			break
+		}
+		addr, proto = net.JoinHostPort(ip.String(), port), hops[i].proto
+		if !contains_ip(opt.Networks, ip) {
+			break
+		}
+	}
+	if proto == "" && len(hops) != 0 {
+		proto = hops[0].proto
+	}
+	return addr, proto
+}
+
+// request_scheme returns scheme of client
+func request_scheme(r *http.Request) string {
+	if info, ok := r.Context().Value(clientKey{}).(*clientInfo); ok && info.Scheme != "" {
+		return info.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// request_peer returns address of peer if it is a trusted proxy
+func request_peer(r *http.Request) string {
+	if info, ok := r.Context().Value(clientKey{}).(*clientInfo); ok && info.Peer != r.RemoteAddr {
+		return info.Peer
+	}
+	return ""
+}
+
+// resolve_client replaces RemoteAddr with client address if peer is trusted proxy
+func (h *ZipHandler) resolve_client(r *http.Request) *http.Request {
+	if h.proxy == nil {
+		return r
+	}
+	res := clientInfo{Peer: r.RemoteAddr}
+	if info := proxy_info(r.Context()); info != nil {
+		res = *info
+	}
+	ip := remote_ip(r)
+	if ip == nil || !contains_ip(h.proxy.Networks, ip) {
+		if res.Peer != r.RemoteAddr {
+			r = r.WithContext(context.WithValue(r.Context(), clientKey{}, &res))
+		}
+		return r
+	}
+	var hops []forwardedHop
+	if values := r.Header.Values("Forwarded"); len(values) != 0 {
+		hops = parse_forwarded(values)
+	} else {
+		hops = parse_xforwarded(r.Header.Values("X-Forwarded-For"), r.Header.Values("X-Forwarded-Proto"))
+	}
+	addr, proto := h.proxy.resolve(hops)
+	if proto == "http" || proto == "https" {
+		res.Scheme = proto
+	}
+	r = r.WithContext(context.WithValue(r.Context(), clientKey{}, &res))
+	if addr != "" {
+		r.RemoteAddr = addr
+	}
+	return r
+}
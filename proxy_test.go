@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestResolveClient(t *testing.T) {
+	t.Parallel()
+	networks, err := parse_networks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Error("parse", err)
+		return
+	}
+	hdl := ZipHandler{proxy: &ProxyOption{Networks: networks}}
+	for _, tc := range []struct {
+		remote  string
+		headers map[string]string
+		addr    string
+		scheme  string
+		peer    string
+	}{
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1, 10.0.0.2", "X-Forwarded-Proto": "https"}, "192.0.2.1:0", "https", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1, 192.0.2.1"}, "192.0.2.1:0", "http", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`}, "[2001:db8::1]:4711", "https", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown;proto=https"}, "unknown", "https", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=192.0.2.1, for=_hidden, for=10.0.0.2"}, "_hidden", "http", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "proto=https"}, "unknown", "https", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1, garbage, 10.0.0.2"}, "garbage", "http", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", map[string]string{}, "10.0.0.1:1234", "http", ""},
+		{"192.0.2.9:1234", map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Proto": "https"}, "192.0.2.9:1234", "http", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		req = hdl.resolve_client(req)
+		if req.RemoteAddr != tc.addr {
+			t.Error("addr", tc.headers, req.RemoteAddr)
+		}
+		if scheme := request_scheme(req); scheme != tc.scheme {
+			t.Error("scheme", tc.headers, scheme)
+		}
+		if peer := request_peer(req); peer != tc.peer {
+			t.Error("peer", tc.headers, peer)
+		}
+	}
+}
+
+func TestReadProxyV1(t *testing.T) {
+	t.Parallel()
+	rd := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\n"))
+	hdr, err := read_proxy(rd)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	if hdr.source.String() != "192.0.2.1:56324" {
+		t.Error("source", hdr.source)
+	}
+	if rest, _ := io.ReadAll(rd); string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Error("rest", string(rest))
+	}
+	for _, invalid := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 x 192.0.2.2 1 2\r\n", "PROXY TCP4 192.0.2.1\r\n"} {
+		if _, err := read_proxy(bufio.NewReader(bytes.NewBufferString(invalid))); err == nil {
+			t.Error("no error", invalid)
+		}
+	}
+}
+
+func TestReadProxyV2(t *testing.T) {
+	t.Parallel()
+	payload := []byte{192, 0, 2, 1, 192, 0, 2, 2}
+	payload = binary.BigEndian.AppendUint16(payload, 56324)
+	payload = binary.BigEndian.AppendUint16(payload, 443)
+	payload = append(payload, proxyV2TypeSSL, 0, 5, proxyV2ClientSSL, 0, 0, 0, 0)
+	buf := append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x21, 0x11)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	buf = append(buf, payload...)
+	buf = append(buf, "GET /"...)
+	rd := bufio.NewReader(bytes.NewBuffer(buf))
+	hdr, err := read_proxy(rd)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	if hdr.source.String() != "192.0.2.1:56324" || !hdr.tls {
+		t.Error("header", hdr.source, hdr.tls)
+	}
+	if rest, _ := io.ReadAll(rd); string(rest) != "GET /" {
+		t.Error("rest", string(rest))
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	t.Parallel()
+	networks, _ := parse_networks([]string{"127.0.0.0/8"})
+	hdl := ZipHandler{proxy: &ProxyOption{Networks: networks}}
+	var remote, peer string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = hdl.resolve_client(r)
+		remote, peer = r.RemoteAddr, request_peer(r)
+	}))
+	srv.Listener = &proxyListener{Listener: srv.Listener, networks: networks}
+	srv.Config.ConnContext = proxy_context
+	srv.Start()
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Error("dial", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 80\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Error("write", err)
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Error("response", err)
+		return
+	}
+	resp.Body.Close()
+	if remote != "192.0.2.1:56324" {
+		t.Error("remote", remote)
+	}
+	if peer == "" {
+		t.Error("peer is empty")
+	}
+}
+
+func TestProxyConnReadFrom(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("listen", err)
+		return
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Error("dial", err)
+		return
+	}
+	defer conn.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Error("accept", err)
+		return
+	}
+	var pc net.Conn = &proxyConn{Conn: server, rd: bufio.NewReader(server)}
+	rf, ok := pc.(io.ReaderFrom)
+	if !ok {
+		t.Error("not a ReaderFrom")
+		return
+	}
+	if _, ok = server.(io.ReaderFrom); !ok {
+		t.Error("underlying conn is not a ReaderFrom")
+	}
+	name := prepare_testzip(t)
+	fp, err := os.Open(name)
+	if err != nil {
+		t.Error("open", err)
+		return
+	}
+	defer fp.Close()
+	go func() {
+		defer server.Close()
+		if written, err := rf.ReadFrom(&io.LimitedReader{R: fp, N: 100}); err != nil || written != 100 {
+			t.Error("readfrom", written, err)
+		}
+	}()
+	got, err := io.ReadAll(conn)
+	if err != nil || !bytes.Equal(got, testzip[:100]) {
+		t.Error("read", len(got), err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+const (
+	proxyV2TypeSSL     = 0x20
+	proxyV2ClientSSL   = 0x01
+	proxyV1MaxLength   = 107
+	proxyV2HeaderBytes = 16
+)
+
+// proxyHeader is result of PROXY protocol header. source is nil for LOCAL/UNKNOWN
+type proxyHeader struct {
+	source net.Addr
+	tls    bool
+}
+
+// read_proxy_v1 reads "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func read_proxy_v1(rd *bufio.Reader) (*proxyHeader, error) {
+	buf := make([]byte, 0, proxyV1MaxLength)
+	for {
+		ch, err := rd.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, ch)
+		if ch == '\n' {
+			break
+		}
+		if len(buf) >= proxyV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+	}
+	line, ok := strings.CutSuffix(string(buf), "\r\n")
+	if !ok {
+		return nil, ErrInvalidProxyHeader
+	}
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return &proxyHeader{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &proxyHeader{source: &net.TCPAddr{IP: ip, Port: int(port)}}, nil
+}
+
+// read_proxy_v2 reads binary header
+func read_proxy_v2(rd *bufio.Reader) (*proxyHeader, error) {
+	hdr := make([]byte, proxyV2HeaderBytes)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) || hdr[12]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		return nil, err
+	}
+	res := proxyHeader{}
+	if hdr[12]&0x0f == 0 {
+		// LOCAL: health check of proxy itself
+		return &res, nil
+	}
+	var tlvs []byte
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		res.source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		tlvs = payload[12:]
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		res.source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		tlvs = payload[36:]
+	}
+	for len(tlvs) >= 3 {
+		typ, size := tlvs[0], int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if 3+size > len(tlvs) {
+			break
+		}
+		if typ == proxyV2TypeSSL && size >= 1 && tlvs[3]&proxyV2ClientSSL != 0 {
+			res.tls = true
+		}
+		tlvs = tlvs[3+size:]
+	}
+	return &res, nil
+}
+
+// read_proxy reads v1 or v2 header
+func read_proxy(rd *bufio.Reader) (*proxyHeader, error) {
+	head, err := rd.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(head, proxyV2Signature) {
+		return read_proxy_v2(rd)
+	}
+	if head, err := rd.Peek(6); err == nil && string(head) == "PROXY " {
+		return read_proxy_v1(rd)
+	}
+	return nil, ErrInvalidProxyHeader
+}
+
+// proxyConn reads PROXY protocol header on first use
+type proxyConn struct {
+	net.Conn
+	rd      *bufio.Reader
+	once    sync.Once
+	header  *proxyHeader
+	err     error
+	timeout time.Duration
+}
+
+func (c *proxyConn) parse() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err == nil {
+				defer c.Conn.SetReadDeadline(time.Time{})
+			}
+		}
+		c.header, c.err = read_proxy(c.rd)
+		if c.err != nil {
+			slog.Warn("PROXY protocol", "peer", c.Conn.RemoteAddr(), "error", c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.parse()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.rd.Read(b)
+}
+
+// ReadFrom keeps sendfile of underlying *net.TCPConn
+func (c *proxyConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.parse()
+	if c.header != nil && c.header.source != nil {
+		return c.header.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyListener accepts PROXY protocol from trusted proxies
+type proxyListener struct {
+	net.Listener
+	networks []*net.IPNet
+	timeout  time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !contains_ip(l.networks, addr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, rd: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+type proxyConnKey struct{}
+
+// proxy_context passes PROXY protocol connection to handler. ConnContext is called in accept loop, so header is not read here
+func proxy_context(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, pc)
+	}
+	return ctx
+}
+
+// proxy_info returns peer address and scheme of PROXY protocol
+func proxy_info(ctx context.Context) *clientInfo {
+	pc, ok := ctx.Value(proxyConnKey{}).(*proxyConn)
+	if !ok {
+		return nil
+	}
+	pc.parse()
+	res := clientInfo{Peer: pc.Conn.RemoteAddr().String()}
+	if pc.header != nil && pc.header.tls {
+		res.Scheme = "https"
+	}
+	return &res
+}
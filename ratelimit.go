@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	throttleChunk = 16 * 1024
+	sweepInterval = time.Minute
+)
+
+// tokenBucket refills rate tokens per second up to burst
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// allow takes a token. returns duration to wait for next token if empty
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve takes n tokens in advance. returns duration to wait until they are available.
+// debt accumulates, so writers sharing bucket are served in order of reservation
+func (b *tokenBucket) reserve(now time.Time, n int) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns n tokens reserved but not used
+func (b *tokenBucket) cancel(n int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+float64(n))
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// RateLimiter has token bucket for each key
+type RateLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastsweep time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	res := RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), lastsweep: time.Now()}
+	if res.burst < 1 {
+		res.burst = math.Max(1, math.Ceil(rate))
+	}
+	return &res
+}
+
+// sweep removes full buckets, they are same as new one
+func (l *RateLimiter) sweep(now time.Time) {
+	for k, v := range l.buckets {
+		if v.full(now) {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastsweep = now
+}
+
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.lock.Lock()
+	if now.Sub(l.lastsweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(l.rate, l.burst)
+		l.buckets[key] = bucket
+	}
+	l.lock.Unlock()
+	return bucket.allow(now)
+}
+
+func (l *RateLimiter) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.buckets)
+}
+
+// LimitStats counts limited requests and throttled bytes
+type LimitStats struct {
+	Allowed   atomic.Uint64
+	Limited   atomic.Uint64
+	Throttled atomic.Uint64
+	Waited    atomic.Int64
+}
+
+func (s *LimitStats) Map() map[string]any {
+	return map[string]any{
+		"allowed":   s.Allowed.Load(),
+		"limited":   s.Limited.Load(),
+		"throttled": s.Throttled.Load(),
+		"waited":    time.Duration(s.Waited.Load()).String(),
+	}
+}
+
+// limitStats is published as expvar
+var limitStats LimitStats
+
+func init() {
+	expvar.Publish("limiter", expvar.Func(func() any { return limitStats.Map() }))
+}
+
+type prefixLimiter struct {
+	prefix  string
+	limiter *RateLimiter
+}
+
+// RateLimit limits requests per client and bandwidth of responses
+type RateLimit struct {
+	client    *RateLimiter
+	prefixes  []prefixLimiter
+	bandwidth *tokenBucket
+	perconn   float64
+	stats     *LimitStats
+}
+
+// parse_prefix_limit parses "/prefix/=RATE" or "/prefix/=RATE/BURST"
+func parse_prefix_limit(spec string) (prefixLimiter, error) {
+	idx := strings.LastIndex(spec, "=")
+	if idx == -1 {
+		return prefixLimiter{}, fmt.Errorf("invalid rate limit: %s", spec)
+	}
+	ratestr, burststr, _ := strings.Cut(spec[idx+1:], "/")
+	rate, err := strconv.ParseFloat(ratestr, 64)
+	if err != nil || rate <= 0 {
+		return prefixLimiter{}, fmt.Errorf("invalid rate limit: %s", spec)
+	}
+	burst := 0
+	if burststr != "" {
+		if burst, err = strconv.Atoi(burststr); err != nil {
+			return prefixLimiter{}, fmt.Errorf("invalid rate limit: %s", spec)
+		}
+	}
+	return prefixLimiter{prefix: spec[:idx], limiter: NewRateLimiter(rate, burst)}, nil
+}
+
+func NewRateLimit(rate float64, burst int, prefixes []string, bandwidth int64, perconn int64) (*RateLimit, error) {
+	res := RateLimit{perconn: float64(perconn), stats: &limitStats}
+	if rate > 0 {
+		res.client = NewRateLimiter(rate, burst)
+	}
+	for _, spec := range prefixes {
+		pl, err := parse_prefix_limit(spec)
+		if err != nil {
+			return nil, err
+		}
+		res.prefixes = append(res.prefixes, pl)
+	}
+	// longest prefix first
+	slices.SortStableFunc(res.prefixes, func(a, b prefixLimiter) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	if bandwidth > 0 {
+		res.bandwidth = newTokenBucket(float64(bandwidth), float64(bandwidth))
+	}
+	return &res, nil
+}
+
+// allow checks rate of client and path. returns Retry-After if limited
+func (l *RateLimit) allow(r *http.Request) (bool, time.Duration) {
+	key := r.RemoteAddr
+	if ip := remote_ip(r); ip != nil {
+		key = ip.String()
+	}
+	if l.client != nil {
+		if ok, wait := l.client.Allow(key); !ok {
+			l.stats.Limited.Add(1)
+			return false, wait
+		}
+	}
+	urlpath := clean_path(r.URL.Path)
+	for _, pl := range l.prefixes {
+		if strings.HasPrefix(urlpath, pl.prefix) {
+			if ok, wait := pl.limiter.Allow(key); !ok {
+				l.stats.Limited.Add(1)
+				return false, wait
+			}
+			break
+		}
+	}
+	l.stats.Allowed.Add(1)
+	return true, 0
+}
+
+// limit_request responds 429 if limited
+func (l *RateLimit) limit_request(w http.ResponseWriter, r *http.Request, statuscode *int) bool {
+	ok, wait := l.allow(r)
+	if ok {
+		return false
+	}
+	*statuscode = http.StatusTooManyRequests
+	slog.Debug("rate limited", "remote", r.RemoteAddr, "path", r.URL.Path, "wait", wait)
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	http.Error(w, http.StatusText(*statuscode), *statuscode)
+	return true
+}
+
+type connBucketKey struct{}
+
+// bandwidth_context gives token bucket to each connection
+func (l *RateLimit) bandwidth_context(ctx context.Context, conn net.Conn) context.Context {
+	if l.perconn <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connBucketKey{}, newTokenBucket(l.perconn, l.perconn))
+}
+
+// throttle wraps w if bandwidth is limited
+func (l *RateLimit) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	buckets := make([]*tokenBucket, 0, 2)
+	if conn, ok := r.Context().Value(connBucketKey{}).(*tokenBucket); ok {
+		buckets = append(buckets, conn)
+	}
+	if l.bandwidth != nil {
+		buckets = append(buckets, l.bandwidth)
+	}
+	if len(buckets) == 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), buckets: buckets, stats: l.stats}
+}
+
+// log_stats logs statistics of limiter by interval
+func (l *RateLimit) log_stats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			attrs := []any{}
+			for k, v := range l.stats.Map() {
+				attrs = append(attrs, k, v)
+			}
+			if l.client != nil {
+				attrs = append(attrs, "clients", l.client.Len())
+			}
+			slog.Info("limiter stats", attrs...)
+		}
+	}
+}
+
+// throttledWriter waits tokens of buckets before write. it does not implement ReadFrom to avoid sendfile
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+	stats   *LimitStats
+}
+
+func (w *throttledWriter) wait(n int) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range w.buckets {
+		wait = max(wait, b.reserve(now, n))
+	}
+	if wait <= 0 {
+		return nil
+	}
+	w.stats.Throttled.Add(uint64(n))
+	w.stats.Waited.Add(int64(wait))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		for _, b := range w.buckets {
+			b.cancel(n)
+		}
+		return w.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) != 0 {
+		chunk := b[:min(len(b), throttleChunk)]
+		if err := w.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
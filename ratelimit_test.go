@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b := newTokenBucket(2, 2)
+	b.last = now
+	for i := range 2 {
+		if ok, _ := b.allow(now); !ok {
+			t.Error("not allowed", i)
+		}
+	}
+	ok, wait := b.allow(now)
+	if ok || wait != 500*time.Millisecond {
+		t.Error("allowed", ok, wait)
+	}
+	if ok, _ := b.allow(now.Add(500 * time.Millisecond)); !ok {
+		t.Error("not refilled")
+	}
+	if wait := b.reserve(now.Add(500*time.Millisecond), 4); wait != 2*time.Second {
+		t.Error("reserve", wait)
+	}
+	// debt accumulates
+	for range 10 {
+		b.reserve(now.Add(500*time.Millisecond), 4)
+	}
+	if wait := b.reserve(now.Add(500*time.Millisecond), 1); wait != 22500*time.Millisecond {
+		t.Error("reserve", wait)
+	}
+	b.cancel(1)
+	if wait := b.reserve(now.Add(500*time.Millisecond), 1); wait != 22500*time.Millisecond {
+		t.Error("cancel", wait)
+	}
+}
+
+func TestThrottledWriterShared(t *testing.T) {
+	t.Parallel()
+	const (
+		rate    = 1024 * 1024
+		writers = 8
+		size    = 64 * 1024
+	)
+	bucket := newTokenBucket(rate, throttleChunk)
+	stats := &LimitStats{}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", nil)
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &throttledWriter{ResponseWriter: httptest.NewRecorder(), ctx: req.Context(), buckets: []*tokenBucket{bucket}, stats: stats}
+			n, err := w.Write(make([]byte, size))
+			if err != nil {
+				t.Error("write", err)
+			}
+			written.Add(int64(n))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if written.Load() != writers*size {
+		t.Error("written", written.Load())
+	}
+	// total bytes do not exceed burst + rate * elapsed
+	if limit := throttleChunk + rate*elapsed.Seconds(); float64(written.Load()) > limit {
+		t.Error("exceeded", written.Load(), limit, elapsed)
+	}
+}
+
+func TestParsePrefixLimit(t *testing.T) {
+	t.Parallel()
+	pl, err := parse_prefix_limit("/api/=5/10")
+	if err != nil {
+		t.Error("parse", err)
+		return
+	}
+	if pl.prefix != "/api/" || pl.limiter.rate != 5 || pl.limiter.burst != 10 {
+		t.Error("limit", pl.prefix, pl.limiter.rate, pl.limiter.burst)
+	}
+	if pl, _ = parse_prefix_limit("/=0.5"); pl.limiter.burst != 1 {
+		t.Error("burst", pl.limiter.burst)
+	}
+	for _, invalid := range []string{"/api/", "/api/=x", "/api/=-1", "/api/=1/x"} {
+		if _, err := parse_prefix_limit(invalid); err == nil {
+			t.Error("no error", invalid)
+		}
+	}
+}
+
+func TestRateLimitRequest(t *testing.T) {
+	t.Parallel()
+	limit, err := NewRateLimit(0.001, 3, []string{"/4kb=0.001/1"}, 0, 0)
+	if err != nil {
+		t.Error("limit", err)
+		return
+	}
+	limit.stats = &LimitStats{}
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		limit:     limit,
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	for _, tc := range []struct {
+		remote string
+		path   string
+		status int
+	}{
+		{"192.0.2.1:1", "/4kb.txt", http.StatusOK},
+		{"192.0.2.1:2", "/4kb.txt", http.StatusTooManyRequests},
+		{"192.0.2.1:3", "/512b.txt", http.StatusOK},
+		{"192.0.2.1:4", "/512b.txt", http.StatusTooManyRequests},
+		{"192.0.2.2:1", "/4kb.txt", http.StatusOK},
+		{"192.0.2.3:1", "/./4kb.txt", http.StatusOK},
+		{"192.0.2.3:2", "//4kb.txt", http.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.RemoteAddr = tc.remote
+		w := httptest.NewRecorder()
+		hdl.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Error("status", tc, w.Code)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("no Retry-After", tc)
+		}
+	}
+	if limit.stats.Allowed.Load() != 4 || limit.stats.Limited.Load() != 3 {
+		t.Error("stats", limit.stats.Map())
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	t.Parallel()
+	limit, err := NewRateLimit(0, 0, nil, 64*1024, 0)
+	if err != nil {
+		t.Error("limit", err)
+		return
+	}
+	limit.stats = &LimitStats{}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", nil)
+	rec := httptest.NewRecorder()
+	w := limit.throttle(rec, req)
+	start := time.Now()
+	if n, err := w.Write(make([]byte, 96*1024)); err != nil || n != 96*1024 {
+		t.Error("write", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Error("not throttled", elapsed)
+	}
+	if rec.Body.Len() != 96*1024 || limit.stats.Throttled.Load() == 0 {
+		t.Error("written", rec.Body.Len(), limit.stats.Map())
+	}
+	if w := (&RateLimit{stats: &LimitStats{}}).throttle(rec, req); w != rec {
+		t.Error("wrapped without bandwidth")
+	}
+}
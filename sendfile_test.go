@@ -0,0 +1,89 @@
+//go:build unix
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const benchEntrySize = 16 * 1024 * 1024
+
+func bench_zip(b *testing.B) string {
+	b.Helper()
+	name := filepath.Join(b.TempDir(), "bench.zip")
+	fp, err := os.Create(name)
+	if err != nil {
+		b.Fatal("create", err)
+	}
+	defer fp.Close()
+	wr := zip.NewWriter(fp)
+	ofp, err := wr.CreateHeader(&zip.FileHeader{Name: "large.bin", Method: zip.Store})
+	if err != nil {
+		b.Fatal("create header", err)
+	}
+	if _, err = io.CopyN(ofp, rand.Reader, benchEntrySize); err != nil {
+		b.Fatal("write", err)
+	}
+	if err = wr.Close(); err != nil {
+		b.Fatal("close", err)
+	}
+	return name
+}
+
+func cputime(b *testing.B) time.Duration {
+	b.Helper()
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		b.Fatal("getrusage", err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func bench_serve(b *testing.B, nosendfile bool) {
+	name := bench_zip(b)
+	h := ZipHandler{
+		indexname:  "index.html",
+		methodmap:  make(map[string]map[uint16]int),
+		nosendfile: nosendfile,
+	}
+	if err := h.initialize_file([]string{name}); err != nil {
+		b.Fatal("initialize", err)
+	}
+	defer h.Close()
+	srv := httptest.NewServer(&h)
+	defer srv.Close()
+	client := srv.Client()
+	b.SetBytes(benchEntrySize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := cputime(b)
+	for b.Loop() {
+		resp, err := client.Get(srv.URL + "/large.bin")
+		if err != nil {
+			b.Fatal("get", err)
+		}
+		written, err := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil || written != benchEntrySize || resp.StatusCode != http.StatusOK {
+			b.Fatal("response", written, err, resp.StatusCode)
+		}
+	}
+	b.ReportMetric(float64(cputime(b)-start)/float64(b.N), "cpu-ns/op")
+}
+
+func Benchmark_ServeStoreSendfile(b *testing.B) {
+	bench_serve(b, false)
+}
+
+func Benchmark_ServeStoreCopy(b *testing.B) {
+	bench_serve(b, true)
+}
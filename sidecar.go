@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const SidecarSuffix = ".idx"
+
+const SidecarVersion = 3
+
+type ZipIndexEntry struct {
+	Index            int       `json:"index"`
+	Name             string    `json:"name"`
+	Method           uint16    `json:"method"`
+	Record           int64     `json:"record"`
+	Offset           int64     `json:"offset"`
+	CompressedSize   uint64    `json:"csize"`
+	UncompressedSize uint64    `json:"usize"`
+	CRC32            uint32    `json:"crc32"`
+	Modified         time.Time `json:"modified"`
+	Extra            []byte    `json:"extra,omitempty"`
+	ContentType      string    `json:"content-type,omitempty"`
+	Digest           string    `json:"sha256,omitempty"`
+}
+
+type ZipIndex struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Base     int64           `json:"base"`
+	Files    int             `json:"files"`
+	Entries  []ZipIndexEntry `json:"entries"`
+}
+
+func sidecarFilename(archive string) string {
+	return archive + SidecarSuffix
+}
+
+// central_directory returns offset of central directory and start of zip from head of file
+func central_directory(fp io.ReaderAt, size int64) (int64, int64, error) {
+	// EOCD(22) + max comment(65535)
+	tailsize := min(size, 22+65535)
+	tail := make([]byte, tailsize)
+	if _, err := fp.ReadAt(tail, size-tailsize); err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	idx := bytes.LastIndex(tail, []byte{0x50, 0x4b, 0x05, 0x06})
+	if idx == -1 || idx+22 > len(tail) {
+		return 0, 0, fmt.Errorf("end of central directory not found")
+	}
+	eocd := size - tailsize + int64(idx)
+	cdsize := int64(binary.LittleEndian.Uint32(tail[idx+0xc : idx+0x10]))
+	cdoffset := int64(binary.LittleEndian.Uint32(tail[idx+0x10 : idx+0x14]))
+	if cdsize != 0xffffffff && cdoffset != 0xffffffff && binary.LittleEndian.Uint16(tail[idx+0xa:idx+0xc]) != 0xffff {
+		return eocd - cdsize, eocd - cdsize - cdoffset, nil
+	}
+	// zip64: locator(20) is just before EOCD, zip64 EOCD record(56) is before locator
+	rec := make([]byte, 56)
+	if _, err := fp.ReadAt(rec, eocd-20-56); err != nil {
+		return 0, 0, err
+	}
+	if !bytes.HasPrefix(rec, []byte{0x50, 0x4b, 0x06, 0x06}) {
+		return 0, 0, fmt.Errorf("zip64 end of central directory not found")
+	}
+	cdsize = int64(binary.LittleEndian.Uint64(rec[40:48]))
+	cdoffset = int64(binary.LittleEndian.Uint64(rec[48:56]))
+	return eocd - 20 - 56 - cdsize, eocd - 20 - 56 - cdsize - cdoffset, nil
+}
+
+// CentralDirectoryChecksum calculates sha256 from central directory to end of file.
+// position of central directory is also hashed to detect prepended data
+func CentralDirectoryChecksum(archive string) (string, error) {
+	fp, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	st, err := fp.Stat()
+	if err != nil {
+		return "", err
+	}
+	start, _, err := central_directory(fp, st.Size())
+	if err != nil {
+		slog.Error("central directory", "name", archive, "error", err)
+		return "", err
+	}
+	hash := sha256.New()
+	if err = binary.Write(hash, binary.LittleEndian, start); err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(hash, io.NewSectionReader(fp, start, st.Size()-start)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// directory_records returns offset of each record in central directory from head of file
+func directory_records(fp io.ReaderAt, start int64, size int64, files int) ([]int64, error) {
+	rd := bufio.NewReader(io.NewSectionReader(fp, start, size-start))
+	res := make([]int64, 0, files)
+	pos := start
+	hdr := make([]byte, 46)
+	for range files {
+		if _, err := io.ReadFull(rd, hdr); err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(hdr, []byte{0x50, 0x4b, 0x01, 0x02}) {
+			return nil, fmt.Errorf("invalid central directory record at %d", pos)
+		}
+		varlen := int64(binary.LittleEndian.Uint16(hdr[28:30])) + int64(binary.LittleEndian.Uint16(hdr[30:32])) + int64(binary.LittleEndian.Uint16(hdr[32:34]))
+		if _, err := rd.Discard(int(varlen)); err != nil {
+			return nil, err
+		}
+		res = append(res, pos)
+		pos += 46 + varlen
+	}
+	return res, nil
+}
+
+// BuildZipIndex reads archive. SHA-256 is taken from extra field, or computed if digest is set
+func BuildZipIndex(archive string, digest bool) (*ZipIndex, error) {
+	checksum, err := CentralDirectoryChecksum(archive)
+	if err != nil {
+		return nil, err
+	}
+	zf, err := zip.OpenReader(archive)
+	if err != nil {
+		slog.Error("open reader", "name", archive, "error", err)
+		return nil, err
+	}
+	defer zf.Close()
+	fp, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	st, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start, base, err := central_directory(fp, st.Size())
+	if err != nil {
+		return nil, err
+	}
+	records, err := directory_records(fp, start, st.Size(), len(zf.File))
+	if err != nil {
+		slog.Error("central directory", "name", archive, "error", err)
+		return nil, err
+	}
+	res := ZipIndex{
+		Version:  SidecarVersion,
+		Checksum: checksum,
+		Base:     base,
+		Files:    len(zf.File),
+		Entries:  make([]ZipIndexEntry, 0, len(zf.File)),
+	}
+	for i, fi := range zf.File {
+		ent := ZipIndexEntry{
+			Index:            i,
+			Name:             fi.Name,
+			Method:           fi.Method,
+			Record:           records[i],
+			CompressedSize:   fi.CompressedSize64,
+			UncompressedSize: fi.UncompressedSize64,
+			CRC32:            fi.CRC32,
+			Modified:         fi.Modified,
+		}
+		if strings.Contains(fi.Name, "..") || fi.FileInfo().IsDir() {
+			res.Entries = append(res.Entries, ent)
+			continue
+		}
+		if ent.Offset, err = fi.DataOffset(); err != nil {
+			slog.Error("data offset", "name", fi.Name, "error", err)
+			return nil, err
+		}
+		ent.ContentType = make_contenttype(fi.Comment)
+		if ent.ContentType == "" {
+			ent.ContentType = make_contentbyext(fi.Name)
+		}
+		if meta, err := ParseZipMeta(fi.Extra); err == nil && meta != nil {
+			ent.Extra = fi.Extra
+			ent.Digest = meta.Digest
+		}
+		if ent.Digest == "" && digest {
+			if ent.Digest, err = file_digest(fi); err != nil {
+				slog.Error("digest", "name", fi.Name, "error", err)
+				return nil, err
+			}
+		}
+		res.Entries = append(res.Entries, ent)
+	}
+	return &res, nil
+}
+
+func WriteZipIndex(archive string, output string, digest bool) error {
+	idx, err := BuildZipIndex(archive, digest)
+	if err != nil {
+		return err
+	}
+	ofp, err := os.Create(output)
+	if err != nil {
+		slog.Error("create sidecar", "name", output, "error", err)
+		return err
+	}
+	defer ofp.Close()
+	if err = json.NewEncoder(ofp).Encode(idx); err != nil {
+		slog.Error("write sidecar", "name", output, "error", err)
+		return err
+	}
+	slog.Info("sidecar written", "name", output, "entries", len(idx.Entries))
+	return ofp.Close()
+}
+
+// LoadZipIndex reads sidecar of archive and checks it is up to date
+func LoadZipIndex(archive string) (*ZipIndex, error) {
+	fp, err := os.Open(sidecarFilename(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	var res ZipIndex
+	if err = json.NewDecoder(fp).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Version != SidecarVersion {
+		return nil, fmt.Errorf("unsupported sidecar version: %d", res.Version)
+	}
+	checksum, err := CentralDirectoryChecksum(archive)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != res.Checksum {
+		return nil, fmt.Errorf("sidecar checksum mismatch: %s != %s", res.Checksum, checksum)
+	}
+	if len(res.Entries) != res.Files {
+		return nil, fmt.Errorf("sidecar entries mismatch: %d != %d", len(res.Entries), res.Files)
+	}
+	for i, ent := range res.Entries {
+		if ent.Index != i {
+			return nil, fmt.Errorf("sidecar index mismatch: %d != %d", ent.Index, i)
+		}
+	}
+	return &res, nil
+}
+
+// ZipFileIndexed serves archive by sidecar without parsing central directory.
+// *zip.File of an entry is made from its own record on first use
+type ZipFileIndexed struct {
+	fp      *os.File
+	name    string
+	stat    os.FileInfo
+	base    int64
+	size    int64
+	entries []ZipIndexEntry
+	headers []zip.FileHeader
+	readers []atomic.Pointer[zip.Reader]
+	names   map[string]int
+}
+
+func (z *ZipFileIndexed) Open(name string) (fs.File, error) {
+	idx, ok := z.names[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	rd, err := z.reader(idx)
+	if err != nil {
+		return nil, err
+	}
+	return rd.Open(name)
+}
+
+func (z *ZipFileIndexed) File(idx int) *zip.File {
+	rd, err := z.reader(idx)
+	if err != nil {
+		slog.Error("read central directory record", "name", z.name, "idx", idx, "error", err)
+		return nil
+	}
+	return rd.File[0]
+}
+
+// FileHeader returns header from sidecar, without reading archive
+func (z *ZipFileIndexed) FileHeader(idx int) *zip.FileHeader {
+	return &z.headers[idx]
+}
+
+func (z *ZipFileIndexed) Index() []ZipIndexEntry {
+	return z.entries
+}
+
+func (z *ZipFileIndexed) Files() int {
+	return len(z.entries)
+}
+
+func (z *ZipFileIndexed) Name() string {
+	return z.name
+}
+
+func (z *ZipFileIndexed) Close() error {
+	return z.fp.Close()
+}
+
+func (z *ZipFileIndexed) OpenSection(idx int, fi *zip.File) (io.ReadCloser, error) {
+	return open_section(z.name, z.stat, z.entries[idx].Offset, int64(z.entries[idx].CompressedSize))
+}
+
+// reader makes single entry zip, which is the archive with central directory of idx only
+func (z *ZipFileIndexed) reader(idx int) (*zip.Reader, error) {
+	if rd := z.readers[idx].Load(); rd != nil {
+		return rd, nil
+	}
+	ent := z.entries[idx]
+	hdr := make([]byte, 46)
+	if _, err := z.fp.ReadAt(hdr, ent.Record); err != nil {
+		return nil, err
+	}
+	varlen := int(binary.LittleEndian.Uint16(hdr[28:30])) + int(binary.LittleEndian.Uint16(hdr[30:32])) + int(binary.LittleEndian.Uint16(hdr[32:34]))
+	record := make([]byte, 46+varlen)
+	if _, err := z.fp.ReadAt(record, ent.Record); err != nil {
+		return nil, err
+	}
+	size := z.size - z.base
+	tail := bytes.NewBuffer(record)
+	// zip64 end of central directory record, locator and end of central directory
+	for _, v := range []any{
+		uint32(0x06064b50), uint64(44), uint16(45), uint16(45), uint32(0), uint32(0),
+		uint64(1), uint64(1), uint64(len(record)), uint64(size),
+		uint32(0x07064b50), uint32(0), uint64(size + int64(len(record))), uint32(1),
+		uint32(0x06054b50), uint16(0), uint16(0), uint16(0xffff), uint16(0xffff),
+		uint32(0xffffffff), uint32(0xffffffff), uint16(0),
+	} {
+		_ = binary.Write(tail, binary.LittleEndian, v)
+	}
+	src := &indexedReader{fp: z.fp, base: z.base, size: size, tail: tail.Bytes()}
+	rd, err := zip.NewReader(src, size+int64(tail.Len()))
+	if err != nil {
+		return nil, err
+	}
+	if len(rd.File) != 1 || rd.File[0].Name != ent.Name {
+		return nil, fmt.Errorf("central directory record mismatch: %s", ent.Name)
+	}
+	z.readers[idx].Store(rd)
+	return rd, nil
+}
+
+// indexedReader shows zip part of archive followed by tail
+type indexedReader struct {
+	fp   io.ReaderAt
+	base int64
+	size int64
+	tail []byte
+}
+
+func (r *indexedReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	if off < r.size {
+		m := min(int64(len(p)), r.size-off)
+		k, err := r.fp.ReadAt(p[:m], r.base+off)
+		n += k
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		off += m
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	if off-r.size >= int64(len(r.tail)) {
+		return n, io.EOF
+	}
+	k := copy(p, r.tail[off-r.size:])
+	n += k
+	if k < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// NewZipFileIndexed opens archive by up-to-date sidecar
+func NewZipFileIndexed(name string) (*ZipFileIndexed, error) {
+	idx, err := LoadZipIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	res := ZipFileIndexed{
+		fp:      fp,
+		name:    name,
+		stat:    st,
+		base:    idx.Base,
+		size:    st.Size(),
+		entries: idx.Entries,
+		headers: make([]zip.FileHeader, len(idx.Entries)),
+		readers: make([]atomic.Pointer[zip.Reader], len(idx.Entries)),
+		names:   make(map[string]int, len(idx.Entries)),
+	}
+	for i, ent := range idx.Entries {
+		res.headers[i] = zip.FileHeader{
+			Name:               ent.Name,
+			Method:             ent.Method,
+			CRC32:              ent.CRC32,
+			CompressedSize64:   ent.CompressedSize,
+			UncompressedSize64: ent.UncompressedSize,
+			Extra:              ent.Extra,
+			Modified:           ent.Modified,
+		}
+		if _, ok := res.names[ent.Name]; !ok {
+			res.names[ent.Name] = i
+		}
+	}
+	return &res, nil
+}
+
+type SidecarCmd struct {
+	Output string `short:"o" long:"output" description:"output filename (default: <archive>.idx)"`
+	Digest bool   `long:"digest" description:"compute SHA-256 of entries not in extra field"`
+}
+
+func (cmd *SidecarCmd) Execute(args []string) (err error) {
+	init_log()
+	filename := archiveFilename()
+	output := cmd.Output
+	if output == "" {
+		output = sidecarFilename(filename)
+	}
+	return WriteZipIndex(filename, output, cmd.Digest)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipIndexWriteLoad(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	if err := WriteZipIndex(name, sidecarFilename(name), false); err != nil {
+		t.Error("write index", err)
+		return
+	}
+	idx, err := LoadZipIndex(name)
+	if err != nil {
+		t.Error("load index", err)
+		return
+	}
+	if idx.Files != 4 || len(idx.Entries) != 4 {
+		t.Error("entries", idx.Files, len(idx.Entries))
+	}
+	for _, ent := range idx.Entries {
+		if ent.Name == "512b.txt" {
+			if ent.UncompressedSize != 512 || ent.CompressedSize != 512 {
+				t.Error("size", ent)
+			}
+			if ent.ContentType != "text/plain; charset=utf-8" {
+				t.Error("content-type", ent.ContentType)
+			}
+		}
+	}
+}
+
+func TestZipIndexChecksumMismatch(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	if err := WriteZipIndex(name, sidecarFilename(name), false); err != nil {
+		t.Error("write index", err)
+		return
+	}
+	if err := createSimpleZip(name, "other.txt", []byte("other")); err != nil {
+		t.Error("overwrite zip", err)
+		return
+	}
+	if _, err := LoadZipIndex(name); err == nil {
+		t.Error("expected checksum error")
+	}
+}
+
+func TestCentralDirectoryChecksumSelf(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	selfname := filepath.Join(t.TempDir(), "self.run")
+	if err = os.WriteFile(selfname, append(bytes.Repeat([]byte{0}, 1000), data...), 0o600); err != nil {
+		t.Error("write", err)
+		return
+	}
+	sum1, err := CentralDirectoryChecksum(name)
+	if err != nil {
+		t.Error("checksum", err)
+		return
+	}
+	sum2, err := CentralDirectoryChecksum(selfname)
+	if err != nil {
+		t.Error("checksum(self)", err)
+		return
+	}
+	if sum1 == sum2 {
+		t.Error("same checksum for different offsets")
+	}
+	if _, err = CentralDirectoryChecksum(filepath.Join(t.TempDir(), "notfound.zip")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestInitializeWithSidecar(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	selfname := filepath.Join(t.TempDir(), "self.run")
+	if err = os.WriteFile(selfname, append(bytes.Repeat([]byte{0}, 1000), data...), 0o600); err != nil {
+		t.Error("write", err)
+		return
+	}
+	for _, archive := range []string{name, selfname} {
+		if err := WriteZipIndex(archive, sidecarFilename(archive), false); err != nil {
+			t.Error("write index", err)
+			return
+		}
+		h := ZipHandler{indexname: "index.html", methodmap: make(map[string]map[uint16]int)}
+		if err := h.initialize([]string{archive}, false); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+		indexed, ok := h.zipfiles[0].(*ZipFileIndexed)
+		if !ok {
+			t.Error("sidecar not used", archive)
+			return
+		}
+		if len(h.ctypes) != 4 || len(h.methodmap) != 4 {
+			t.Error("methodmap", len(h.ctypes), len(h.methodmap))
+		}
+		for i := range indexed.readers {
+			if indexed.readers[i].Load() != nil {
+				t.Error("entry is read at load", i)
+			}
+		}
+		for _, fname := range []string{"4kb.txt", "512b.txt"} {
+			req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/"+fname, bytes.NewBuffer([]byte{}))
+			req.Header.Set("Accept-Encoding", "gzip")
+			got := httptest.NewRecorder()
+			h.ServeHTTP(got, req)
+			if got.Code != http.StatusOK {
+				t.Error("status", fname, got.Code)
+			}
+			if ctype := got.Result().Header.Get("Content-Type"); ctype != "text/plain; charset=utf-8" {
+				t.Error("content-type", ctype)
+			}
+			if fname == "4kb.txt" && got.Result().Header.Get("Content-Encoding") != "gzip" {
+				t.Error("content-encoding", got.Result().Header)
+			}
+			if fname == "512b.txt" && got.Body.Len() != 512 {
+				t.Error("body", got.Body.Len())
+			}
+		}
+		fi := indexed.File(indexed.names["512b.txt"])
+		rd, err := fi.Open()
+		if err != nil {
+			t.Error("open", err)
+			return
+		}
+		body, err := io.ReadAll(rd)
+		if err != nil || len(body) != 512 {
+			t.Error("read", len(body), err)
+		}
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			t.Error("open reader", err)
+			return
+		}
+		for i, orig := range zr.File {
+			if !indexed.FileHeader(i).Modified.Equal(orig.Modified) {
+				t.Error("modified", orig.Name, indexed.FileHeader(i).Modified, orig.Modified)
+			}
+		}
+		zr.Close()
+		if _, err = indexed.Open("notfound.txt"); err == nil {
+			t.Error("open notfound")
+		}
+		_ = h.Close()
+	}
+	h := ZipHandler{methodmap: make(map[string]map[uint16]int), nosidecar: true}
+	if err := h.initialize_file([]string{name}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	defer h.Close()
+	if _, ok := h.zipfiles[0].(*ZipFileFile); !ok || len(h.ctypes) != 0 {
+		t.Error("sidecar used", len(h.ctypes))
+	}
+	if len(h.methodmap) != 4 {
+		t.Error("methodmap", len(h.methodmap))
+	}
+}
+
+func TestSidecarCommand(t *testing.T) {
+	name := prepare_testzip(t)
+	output := filepath.Join(t.TempDir(), "out.idx")
+	runcmd_test(t, []string{"ziphttp", "sidecar", "-f", name, "-o", output}, 0)
+	if _, err := os.Stat(output); err != nil {
+		t.Error("sidecar not created", err)
+	}
+	runcmd_test(t, []string{"ziphttp", "sidecar", "-f", name}, 0)
+	if _, err := LoadZipIndex(name); err != nil {
+		t.Error("load", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals reopens access log
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
+
+// maintenanceSignals toggles maintenance mode
+var maintenanceSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type TranscodeOption struct {
+	Encodings []string
+	Workers   int
+	Level     int
+	CacheDir  string
+}
+
+var transcode_methods = map[string]uint16{
+	"br":   Brotli,
+	"zstd": Zstd,
+}
+
+// ZipFileTranscoded holds entries transcoded in background.
+// each job has its own slot, which worker fills without lock of handler
+type ZipFileTranscoded struct {
+	entries []atomic.Pointer[transcodedEntry]
+}
+
+// transcodedEntry is single entry zip in memory, or in cache file if path is set
+type transcodedEntry struct {
+	fi     *zip.File
+	path   string
+	fp     *os.File
+	offset int64
+}
+
+func (z *ZipFileTranscoded) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (z *ZipFileTranscoded) File(idx int) *zip.File {
+	if ent := z.entries[idx].Load(); ent != nil {
+		return ent.fi
+	}
+	return nil
+}
+
+func (z *ZipFileTranscoded) Files() int {
+	return len(z.entries)
+}
+
+// Close closes cache files of entries
+func (z *ZipFileTranscoded) Close() error {
+	var res error
+	for i := range z.entries {
+		if ent := z.entries[i].Load(); ent != nil && ent.fp != nil {
+			if err := ent.fp.Close(); err != nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+// OpenSection opens cache file again to have own file position for sendfile
+func (z *ZipFileTranscoded) OpenSection(idx int, fi *zip.File) (io.ReadCloser, error) {
+	if ent := z.entries[idx].Load(); ent != nil && ent.path != "" {
+		stat, err := ent.fp.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return open_section(ent.path, stat, ent.offset, int64(fi.CompressedSize64))
+	}
+	rd, err := fi.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(rd), nil
+}
+
+// ctxReader stops reading when ctx is canceled
+type ctxReader struct {
+	ctx context.Context
+	rd  io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.rd.Read(p)
+}
+
+type transcodeJob struct {
+	idx      int
+	fi       *zip.File
+	method   uint16
+	ctype    string
+	bymethod map[uint16]int
+}
+
+type Transcoder struct {
+	opt      TranscodeOption
+	output   *ZipFileTranscoded
+	base     int
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	variants sync.Map
+}
+
+// methods returns bymethod of name with transcoded entries
+func (tr *Transcoder) methods(name string, bymethod map[uint16]int) map[uint16]int {
+	if v, ok := tr.variants.Load(name); ok {
+		return v.(map[uint16]int)
+	}
+	return bymethod
+}
+
+// publish makes transcoded entry visible to requests. bymethod is copied not to modify methodmap
+func (tr *Transcoder) publish(job transcodeJob, idx int) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	bymethod := job.bymethod
+	if v, ok := tr.variants.Load(job.fi.Name); ok {
+		bymethod = v.(map[uint16]int)
+	}
+	bymethod = maps.Clone(bymethod)
+	bymethod[job.method] = idx
+	tr.variants.Store(job.fi.Name, bymethod)
+}
+
+// transcode_jobs lists deflate entries which do not have target encodings yet. caller must hold lock
+func (h *ZipHandler) transcode_jobs() []transcodeJob {
+	res := make([]transcodeJob, 0)
+	names := make([]string, 0, len(h.methodmap))
+	for name := range h.methodmap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		bymethod := h.methodmap[name]
+		idx, ok := bymethod[zip.Deflate]
+		if !ok {
+			continue
+		}
+		for _, enc := range h.transcode.Encodings {
+			if _, ok := dynamic_compressors[enc]; !ok {
+				// not supported in this build(zstd without cgo)
+				continue
+			}
+			method := transcode_methods[enc]
+			if _, ok := bymethod[method]; ok {
+				continue
+			}
+			fi := h.getidx(idx)
+			if fi == nil {
+				continue
+			}
+			res = append(res, transcodeJob{idx: idx, fi: fi, method: method, ctype: h.contenttype(idx, fi), bymethod: bymethod})
+		}
+	}
+	return res
+}
+
+// stop_transcode cancels workers and waits for them to exit. caller must hold lock
+func (h *ZipHandler) stop_transcode() {
+	if tr := h.transcoder; tr != nil {
+		h.transcoder = nil
+		tr.cancel()
+		tr.wg.Wait()
+	}
+}
+
+// start_transcode starts workers for current archives. caller must hold lock
+func (h *ZipHandler) start_transcode() {
+	h.transcoder = nil
+	if h.transcode == nil {
+		return
+	}
+	jobs := h.transcode_jobs()
+	if len(jobs) == 0 {
+		slog.Debug("nothing to transcode")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tr := &Transcoder{opt: *h.transcode, output: &ZipFileTranscoded{entries: make([]atomic.Pointer[transcodedEntry], len(jobs))}, ctx: ctx, cancel: cancel}
+	for _, zf := range h.zipfiles {
+		tr.base += zf.Files()
+	}
+	for i, job := range jobs {
+		h.ctypes[tr.base+i] = job.ctype
+	}
+	h.zipfiles = append(h.zipfiles, tr.output)
+	h.transcoder = tr
+	type slotJob struct {
+		slot int
+		job  transcodeJob
+	}
+	ch := make(chan slotJob)
+	for range max(tr.opt.Workers, 1) {
+		tr.wg.Add(1)
+		go func() {
+			defer tr.wg.Done()
+			for sj := range ch {
+				tr.transcode_one(sj.slot, sj.job)
+			}
+		}()
+	}
+	go func() {
+		defer close(ch)
+		for i, job := range jobs {
+			select {
+			case ch <- slotJob{slot: i, job: job}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	// keep source archives until workers finished
+	release := h.acquire()
+	go func() {
+		tr.wg.Wait()
+		release()
+		slog.Info("transcode finished", "jobs", len(jobs), "canceled", ctx.Err() != nil)
+	}()
+	slog.Info("transcode started", "jobs", len(jobs), "workers", max(tr.opt.Workers, 1))
+}
+
+// transcode_one fills slot of output and publishes it. handler lock is not taken not to block requests
+func (tr *Transcoder) transcode_one(slot int, job transcodeJob) {
+	if tr.ctx.Err() != nil {
+		return
+	}
+	ent, err := tr.transcode(job.fi, job.method)
+	if err != nil {
+		if tr.ctx.Err() == nil {
+			slog.Error("transcode", "name", job.fi.Name, "method", job.method, "error", err)
+		}
+		return
+	}
+	tr.output.entries[slot].Store(ent)
+	tr.publish(job, tr.base+slot)
+	slog.Debug("transcoded", "name", job.fi.Name, "method", job.method, "original", job.fi.CompressedSize64, "size", ent.fi.CompressedSize64, "cache", ent.path)
+}
+
+// cachefile is named by content, method and level of output
+func (tr *Transcoder) cachefile(fi *zip.File, method uint16) string {
+	return filepath.Join(tr.opt.CacheDir, fmt.Sprintf("%08x-%d.%04x.%d.zip", fi.CRC32, fi.UncompressedSize64, method, tr.opt.Level))
+}
+
+// cached opens single entry zip in cache file. the descriptor is kept until archive is closed
+func (tr *Transcoder) cached(name string) (*transcodedEntry, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	st, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	zr, err := zip.NewReader(fp, st.Size())
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if len(zr.File) != 1 {
+		fp.Close()
+		return nil, fmt.Errorf("invalid transcode cache: %s", name)
+	}
+	offset, err := zr.File[0].DataOffset()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return &transcodedEntry{fi: zr.File[0], path: name, fp: fp, offset: offset}, nil
+}
+
+// transcode makes single entry zip of fi compressed with method. it is kept in memory if cache dir is not set
+func (tr *Transcoder) transcode(fi *zip.File, method uint16) (*transcodedEntry, error) {
+	if tr.opt.CacheDir != "" {
+		if ent, err := tr.cached(tr.cachefile(fi, method)); err == nil {
+			if ent.fi.CRC32 == fi.CRC32 && ent.fi.Method == method {
+				slog.Debug("transcode cache hit", "name", fi.Name, "method", method)
+				return ent, nil
+			}
+			ent.fp.Close()
+		}
+	}
+	rd, err := fi.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	buf := bytes.Buffer{}
+	wr := zip.NewWriter(&buf)
+	switch method {
+	case Brotli:
+		MakeBrotliWriter(wr, tr.opt.Level)
+	case Zstd:
+		MakeZstdWriter(wr, tr.opt.Level)
+	}
+	fh := fi.FileHeader
+	fh.Method = method
+	ofp, err := wr.CreateHeader(&fh)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(ofp, ctxReader{ctx: tr.ctx, rd: rd}); err != nil {
+		return nil, err
+	}
+	if err = wr.Close(); err != nil {
+		return nil, err
+	}
+	if tr.opt.CacheDir != "" {
+		if err = tr.save(fi, method, buf.Bytes()); err != nil {
+			slog.Warn("transcode cache", "name", fi.Name, "error", err)
+		} else if ent, err := tr.cached(tr.cachefile(fi, method)); err == nil {
+			return ent, nil
+		} else {
+			slog.Warn("transcode cache", "name", fi.Name, "error", err)
+		}
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return nil, err
+	}
+	return &transcodedEntry{fi: zr.File[0]}, nil
+}
+
+func (tr *Transcoder) save(fi *zip.File, method uint16, data []byte) error {
+	ofp, err := os.CreateTemp(tr.opt.CacheDir, ".transcode-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ofp.Name())
+	if _, err = ofp.Write(data); err != nil {
+		ofp.Close()
+		return err
+	}
+	if err = ofp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(ofp.Name(), tr.cachefile(fi, method))
+}
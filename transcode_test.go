@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func transcode_wait(hdl *ZipHandler) {
+	hdl.rwlock.RLock()
+	tr := hdl.transcoder
+	hdl.rwlock.RUnlock()
+	if tr != nil {
+		tr.wg.Wait()
+	}
+}
+
+func transcoded(hdl *ZipHandler, name string, method uint16) (int, bool) {
+	hdl.rwlock.RLock()
+	defer hdl.rwlock.RUnlock()
+	bymethod, _ := hdl.methods(name)
+	idx, ok := bymethod[method]
+	return idx, ok
+}
+
+func TestTranscode(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		transcode: &TranscodeOption{Encodings: []string{"br"}, Workers: 2, Level: -1},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	defer hdl.Close()
+	transcode_wait(&hdl)
+	if _, ok := transcoded(&hdl, "4kb.txt", Brotli); !ok {
+		t.Error("not transcoded", hdl.methodmap["4kb.txt"])
+	}
+	if _, ok := hdl.methodmap["4kb.txt"][Brotli]; ok {
+		t.Error("methodmap is modified", hdl.methodmap["4kb.txt"])
+	}
+	if _, ok := transcoded(&hdl, "512b.txt", Brotli); ok {
+		t.Error("stored entry transcoded", hdl.methodmap["512b.txt"])
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt", bytes.NewBuffer([]byte{}))
+	req.Header.Add("Accept-Encoding", "br, gzip")
+	got := httptest.NewRecorder()
+	hdl.ServeHTTP(got, req)
+	if got.Code != http.StatusOK {
+		t.Error("status", got.Code)
+	}
+	if enc := got.Result().Header.Get("Content-Encoding"); enc != "br" {
+		t.Error("content-encoding", enc)
+	}
+	if ctype := got.Result().Header.Get("Content-Type"); ctype == "" {
+		t.Error("content-type", ctype)
+	}
+	data, err := io.ReadAll(brotli.NewReader(got.Body))
+	if err != nil || len(data) != 4096 {
+		t.Error("decode", len(data), err)
+	}
+}
+
+func TestTranscodeCacheDir(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for _, level := range []int{-1, -1, 5} {
+		hdl := ZipHandler{
+			indexname: "index.html",
+			methodmap: make(map[string]map[uint16]int),
+			transcode: &TranscodeOption{Encodings: []string{"br"}, Workers: 1, Level: level, CacheDir: dir},
+		}
+		if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+		transcode_wait(&hdl)
+		idx, ok := transcoded(&hdl, "4kb.txt", Brotli)
+		if !ok {
+			t.Error("not transcoded", hdl.methodmap["4kb.txt"])
+		} else if fi := hdl.getidx(idx); fi.Method != Brotli || fi.UncompressedSize64 != 4096 {
+			t.Error("transcoded entry", fi.Method, fi.UncompressedSize64)
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/4kb.txt", bytes.NewBuffer([]byte{}))
+		req.Header.Add("Accept-Encoding", "br")
+		got := httptest.NewRecorder()
+		hdl.ServeHTTP(got, req)
+		if data, err := io.ReadAll(brotli.NewReader(got.Body)); err != nil || len(data) != 4096 {
+			t.Error("decode", len(data), err)
+		}
+		ent := hdl.transcoder.output.entries[idx-hdl.transcoder.base].Load()
+		if ent == nil || ent.path == "" || ent.fp == nil {
+			t.Error("transcoded entry is kept in memory")
+			return
+		}
+		if err := hdl.Close(); err != nil {
+			t.Error("close", err)
+		}
+		if _, err := ent.fp.Stat(); err == nil {
+			t.Error("cache file is not closed")
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Error("readdir", err)
+	}
+	if len(entries) == 0 || len(entries)%2 != 0 {
+		t.Error("cache file per level", len(entries))
+	}
+	for _, ent := range entries {
+		zr, err := zip.OpenReader(dir + "/" + ent.Name())
+		if err != nil {
+			t.Error("open cache", ent.Name(), err)
+			continue
+		}
+		if len(zr.File) != 1 || zr.File[0].Method != Brotli {
+			t.Error("cache content", ent.Name())
+		}
+		zr.Close()
+	}
+}
+
+func TestTranscodeReload(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		transcode: &TranscodeOption{Encodings: []string{"br"}, Workers: 1, Level: -1},
+	}
+	for range 3 {
+		if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+			t.Error("initialize", err)
+			return
+		}
+	}
+	transcode_wait(&hdl)
+	defer hdl.Close()
+	if len(hdl.zipfiles) != 2 {
+		t.Error("zipfiles", len(hdl.zipfiles))
+	}
+	if _, ok := transcoded(&hdl, "4kb.txt", Brotli); !ok {
+		t.Error("not transcoded", hdl.methodmap["4kb.txt"])
+	}
+}
+
+func TestTranscodeClose(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+		transcode: &TranscodeOption{Encodings: []string{"br"}, Workers: 1, Level: 11},
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	tr := hdl.transcoder
+	if err := hdl.Close(); err != nil {
+		t.Error("close", err)
+	}
+	filled := func() (res int) {
+		for i := range tr.output.entries {
+			if tr.output.entries[i].Load() != nil {
+				res++
+			}
+		}
+		return
+	}
+	before := filled()
+	time.Sleep(50 * time.Millisecond)
+	if after := filled(); after != before {
+		t.Error("workers are running after close", before, after)
+	}
+}
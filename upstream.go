@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UpstreamOption is backend of paths not in archive. URL is http(s)://host:port/base or unix:/path/to/socket
+type UpstreamOption struct {
+	URL             string
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+}
+
+type Upstream struct {
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+func NewUpstream(opt UpstreamOption) (*Upstream, error) {
+	dialer := &net.Dialer{Timeout: opt.DialTimeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = opt.ResponseTimeout
+	var target *url.URL
+	if sockpath, ok := strings.CutPrefix(opt.URL, "unix:"); ok {
+		target = &url.URL{Scheme: "http", Host: "localhost"}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", sockpath)
+		}
+	} else {
+		var err error
+		if target, err = url.Parse(opt.URL); err != nil {
+			return nil, err
+		}
+		if target.Scheme != "http" && target.Scheme != "https" {
+			return nil, errors.New("upstream must be http(s)://... or unix:/...")
+		}
+	}
+	res := Upstream{target: target}
+	res.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set("X-Forwarded-Proto", request_scheme(pr.In))
+		},
+		Transport:    transport,
+		ErrorHandler: upstream_error,
+	}
+	return &res, nil
+}
+
+func upstream_error(w http.ResponseWriter, r *http.Request, err error) {
+	statuscode := http.StatusBadGateway
+	var neterr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &neterr) && neterr.Timeout()) {
+		statuscode = http.StatusGatewayTimeout
+	}
+	slog.Error("upstream", "path", r.URL.Path, "status", statuscode, "error", err)
+	w.WriteHeader(statuscode)
+}
+
+// statusWriter keeps status code written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if statusCode >= 200 && w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serve proxies request to upstream
+func (u *Upstream) serve(w http.ResponseWriter, r *http.Request, statuscode *int) {
+	slog.Debug("upstream", "path", r.URL.Path, "target", u.target)
+	sw := &statusWriter{ResponseWriter: w}
+	u.proxy.ServeHTTP(sw, r)
+	*statuscode = sw.status
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpstream(t *testing.T) {
+	t.Parallel()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Hop") != "" {
+			t.Error("hop-by-hop header is forwarded")
+		}
+		w.Header().Set("X-Backend", r.Header.Get("X-Forwarded-For"))
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "backend "+r.URL.Path)
+	}))
+	defer backend.Close()
+	upstream, err := NewUpstream(UpstreamOption{URL: backend.URL, DialTimeout: time.Second, ResponseTimeout: time.Second})
+	if err != nil {
+		t.Error("upstream", err)
+		return
+	}
+	hdl, output := accesslog_handler(t, AccessLogOption{Format: "template", Template: "{{.Path}} {{.Status}} {{.Source}}"})
+	hdl.upstream = upstream
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/missing.txt", http.StatusTeapot, "backend /missing.txt"},
+		{"/512b.txt", http.StatusOK, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com"+tc.path, bytes.NewBuffer([]byte{}))
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "1")
+		w := httptest.NewRecorder()
+		hdl.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Error("status", tc.path, w.Code)
+		}
+		if tc.body != "" && (w.Body.String() != tc.body || w.Header().Get("X-Backend") != "192.0.2.1") {
+			t.Error("body", tc.path, w.Body.String(), w.Header())
+		}
+	}
+	buf, err := os.ReadFile(output)
+	if err != nil {
+		t.Error("read", err)
+		return
+	}
+	if string(buf) != "/missing.txt 418 upstream\n/512b.txt 200 archive\n" {
+		t.Error("access log", string(buf))
+	}
+}
+
+func TestUpstreamUnix(t *testing.T) {
+	t.Parallel()
+	sockpath := filepath.Join(t.TempDir(), "backend.sock")
+	listener, err := net.Listen("unix", sockpath)
+	if err != nil {
+		t.Skip("unix socket", err)
+	}
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "unix "+r.URL.Path)
+	}))
+	backend.Listener = listener
+	backend.Start()
+	defer backend.Close()
+	upstream, err := NewUpstream(UpstreamOption{URL: "unix:" + sockpath, DialTimeout: time.Second})
+	if err != nil {
+		t.Error("upstream", err)
+		return
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/path", nil)
+	w := httptest.NewRecorder()
+	statuscode := 0
+	upstream.serve(w, req, &statuscode)
+	if statuscode != http.StatusOK || w.Body.String() != "unix /path" {
+		t.Error("response", statuscode, w.Body.String())
+	}
+}
+
+func TestUpstreamError(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("listen", err)
+		return
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	upstream, err := NewUpstream(UpstreamOption{URL: "http://" + addr, DialTimeout: time.Second})
+	if err != nil {
+		t.Error("upstream", err)
+		return
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/path", nil)
+	w := httptest.NewRecorder()
+	statuscode := 0
+	upstream.serve(w, req, &statuscode)
+	if statuscode != http.StatusBadGateway {
+		t.Error("status", statuscode)
+	}
+	for _, invalid := range []string{"ftp://host/", "://"} {
+		if _, err := NewUpstream(UpstreamOption{URL: invalid}); err == nil {
+			t.Error("no error", invalid)
+		}
+	}
+}
@@ -217,18 +217,15 @@ func fix_link(here string, link string) string {
 	return link
 }
 
-func dochild(node *html.Node, here string) error {
+// walknode calls fn for each element node under node
+func walknode(node *html.Node, fn func(*html.Node) error) error {
 	for c := node.FirstChild; c != nil; c = c.NextSibling {
 		slog.Debug("node", "node", c)
 		if c.Type == html.ElementNode {
-			for idx, v := range c.Attr {
-				if v.Key == "href" || v.Key == "src" {
-					newlink := fix_link(here, v.Val)
-					slog.Debug("fix link", "key", v.Key, "value", v.Val, "new", newlink)
-					c.Attr[idx] = html.Attribute{Key: v.Key, Val: newlink}
-				}
+			if err := fn(c); err != nil {
+				return err
 			}
-			if err := dochild(c, here); err != nil {
+			if err := walknode(c, fn); err != nil {
 				slog.Error("traverse-child", "error", err)
 				return err
 			}
@@ -237,7 +234,31 @@ func dochild(node *html.Node, here string) error {
 	return nil
 }
 
+// dochild fixes links under node if here is not empty, and appends preload links to preload if not nil
+func dochild(node *html.Node, here string, preload *[]string) error {
+	return walknode(node, func(c *html.Node) error {
+		for idx, v := range c.Attr {
+			if here != "" && (v.Key == "href" || v.Key == "src") {
+				newlink := fix_link(here, v.Val)
+				slog.Debug("fix link", "key", v.Key, "value", v.Val, "new", newlink)
+				c.Attr[idx] = html.Attribute{Key: v.Key, Val: newlink}
+			}
+		}
+		if preload != nil {
+			if link := preload_of(c); link != "" {
+				*preload = append(*preload, link)
+			}
+		}
+		return nil
+	})
+}
+
 func LinkRelative_html(here string, reader io.Reader, writer io.Writer) error {
+	return link_relative_html(here, reader, writer, nil)
+}
+
+// link_relative_html fixes links of html, and appends preload links to preload if not nil
+func link_relative_html(here string, reader io.Reader, writer io.Writer, preload *[]string) error {
 	if !ismatch(strings.ToLower(filepath.Base(here)), []string{"*.html", "*.htm"}) {
 		slog.Debug("not match html", "here", here, "base", strings.ToLower(filepath.Base(here)))
 		_, err := io.Copy(writer, reader)
@@ -248,7 +269,7 @@ func LinkRelative_html(here string, reader io.Reader, writer io.Writer) error {
 		slog.Error("parse", "error", err)
 		return err
 	}
-	err = dochild(node, here)
+	err = dochild(node, here, preload)
 	if err != nil {
 		slog.Error("traverse", "error", err)
 		return err
@@ -306,14 +327,23 @@ func LinkRelative(here string, reader io.Reader, writer io.Writer) error {
 	return err
 }
 
-func filtercopy(dst io.Writer, src io.Reader, baseurl string) (int64, error) {
+// filtercopy copies src to dst with links fixed if baseurl is set. preload links of html are collected if preload is not nil
+func filtercopy(dst io.Writer, src io.Reader, baseurl string, preload *[]string) (int64, error) {
+	if baseurl == "" && preload != nil {
+		return preload_copy(dst, src, preload)
+	}
 	if baseurl != "" {
 		rpipe, wpipe := io.Pipe()
 		defer rpipe.Close()
 		var wg sync.WaitGroup
 		wg.Go(func() {
 			defer wpipe.Close()
-			err := LinkRelative(baseurl, src, wpipe)
+			var err error
+			if preload != nil {
+				err = link_relative_html(baseurl, src, wpipe, preload)
+			} else {
+				err = LinkRelative(baseurl, src, wpipe)
+			}
 			if err != nil {
 				slog.Error("linkrelative", "error", err, "baseurl", baseurl)
 			}
@@ -330,9 +360,10 @@ func filtercopy(dst io.Writer, src io.Reader, baseurl string) (int64, error) {
 }
 
 type CompressWork struct {
-	Header *zip.FileHeader
-	Reader io.Reader
-	MyURL  string
+	Header  *zip.FileHeader
+	Reader  io.Reader
+	MyURL   string
+	Preload *[]string
 }
 
 func CompressWorker(name string, wr *zip.Writer, ch <-chan CompressWork) {
@@ -351,7 +382,7 @@ func CompressWorker(name string, wr *zip.Writer, ch <-chan CompressWork) {
 			slog.Error("CreateHeader", "name", job.Header.Name, "method", job.Header.Method, "error", err)
 			return
 		}
-		written, err := filtercopy(fp, job.Reader, job.MyURL)
+		written, err := filtercopy(fp, job.Reader, job.MyURL, job.Preload)
 		if err != nil {
 			slog.Error("Copy", "path", name, "url", job.MyURL, "error", err, "written", written)
 			return
@@ -369,6 +400,46 @@ func CompressWorker(name string, wr *zip.Writer, ch <-chan CompressWork) {
 	}
 }
 
+// ZipPassThruMeta copies files with metadata made by fns
+func ZipPassThruMeta(wr *zip.Writer, files []*zip.File, fns ...func(*zip.File, *ZipMeta) error) error {
+	for _, f := range files {
+		fh := f.FileHeader
+		meta, err := ParseZipMeta(fh.Extra)
+		if err != nil || meta == nil {
+			meta = &ZipMeta{}
+		}
+		for _, fn := range fns {
+			if err = fn(f, meta); err != nil {
+				return err
+			}
+		}
+		if !meta.empty() {
+			if err = SetZipMeta(&fh, meta); err != nil {
+				slog.Error("metadata", "name", f.Name, "error", err)
+				return err
+			}
+		}
+		rd, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		ofp, err := wr.CreateRaw(&fh)
+		if err != nil {
+			slog.Error("CreateRaw", "name", f.Name, "error", err)
+			return err
+		}
+		if _, err = io.Copy(ofp, rd); err != nil {
+			slog.Error("Copy", "name", f.Name, "error", err)
+			return err
+		}
+	}
+	if err := wr.Flush(); err != nil {
+		slog.Error("flush", "error", err)
+		return err
+	}
+	return nil
+}
+
 func ZipPassThru(wr *zip.Writer, files []*zip.File) error {
 	for _, f := range files {
 		if err := wr.Copy(f); err != nil {
@@ -183,7 +183,7 @@ func TestFilterCopy(t *testing.T) {
 	t.Run("with baseurl", func(t *testing.T) {
 		src := strings.NewReader(`<html><body><a href="http://example.com/path/to/a">a</a></body></html>`)
 		dst := &bytes.Buffer{}
-		written, err := filtercopy(dst, src, "http://example.com/path/to/index.html")
+		written, err := filtercopy(dst, src, "http://example.com/path/to/index.html", nil)
 		if err != nil {
 			t.Error("filtercopy", err)
 			return
@@ -199,7 +199,7 @@ func TestFilterCopy(t *testing.T) {
 		srcText := "plain text"
 		src := strings.NewReader(srcText)
 		dst := &bytes.Buffer{}
-		written, err := filtercopy(dst, src, "")
+		written, err := filtercopy(dst, src, "", nil)
 		if err != nil {
 			t.Error("filtercopy no baseurl", err)
 			return
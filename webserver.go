@@ -16,9 +16,11 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,7 +36,8 @@ type ZipFile interface {
 }
 
 type ZipFileBytes struct {
-	z *zip.Reader
+	z    *zip.Reader
+	name string
 }
 
 func (z *ZipFileBytes) Open(name string) (fs.File, error) {
@@ -53,6 +56,10 @@ func (z *ZipFileBytes) Close() error {
 	return nil
 }
 
+func (z *ZipFileBytes) Name() string {
+	return z.name
+}
+
 func NewZipFileBytes(input []byte) (*ZipFileBytes, error) {
 	buf := bytes.NewReader(input)
 	z, err := zip.NewReader(buf, int64(len(input)))
@@ -64,7 +71,9 @@ func NewZipFileBytes(input []byte) (*ZipFileBytes, error) {
 }
 
 type ZipFileFile struct {
-	z *zip.ReadCloser
+	z    *zip.ReadCloser
+	name string
+	stat os.FileInfo
 }
 
 func (z *ZipFileFile) Open(name string) (fs.File, error) {
@@ -83,25 +92,116 @@ func (z *ZipFileFile) Close() error {
 	return z.z.Close()
 }
 
+func (z *ZipFileFile) Name() string {
+	return z.name
+}
+
+type sectionFile struct {
+	io.LimitedReader
+	fp *os.File
+}
+
+func (s *sectionFile) Close() error {
+	return s.fp.Close()
+}
+
+// raw_source unwraps *sectionFile to bare *io.LimitedReader of *os.File, which net/http passes to sendfile(2)
+func raw_source(rd io.Reader) io.Reader {
+	if sf, ok := rd.(*sectionFile); ok {
+		return &sf.LimitedReader
+	}
+	return rd
+}
+
+// OpenSection opens raw data of fi with own file position.
+// net/http uses sendfile(2) for *io.LimitedReader of *os.File
+func (z *ZipFileFile) OpenSection(idx int, fi *zip.File) (io.ReadCloser, error) {
+	offset, err := fi.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	return open_section(z.name, z.stat, offset, int64(fi.CompressedSize64))
+}
+
+var ErrArchiveReplaced = errors.New("archive replaced")
+
+// open_section opens name again and seeks to offset.
+// returns ErrArchiveReplaced if stat is given and name is not the same file, then caller reads by held descriptor
+func open_section(name string, stat os.FileInfo, offset int64, size int64) (io.ReadCloser, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if stat != nil {
+		st, err := fp.Stat()
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		if !os.SameFile(stat, st) {
+			fp.Close()
+			return nil, fmt.Errorf("%s: %w", name, ErrArchiveReplaced)
+		}
+	}
+	if _, err = fp.Seek(offset, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return &sectionFile{LimitedReader: io.LimitedReader{R: fp, N: size}, fp: fp}, nil
+}
+
 func NewZipFileFile(name string) (*ZipFileFile, error) {
+	// stat before open. if replaced between them, sections fall back to the reader
+	stat, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
 	z, err := zip.OpenReader(name)
 	if err != nil {
 		return nil, err
 	}
-	res := ZipFileFile{z: z}
+	res := ZipFileFile{z: z, name: name, stat: stat}
 	return &res, nil
 }
 
 type ZipHandler struct {
-	zipfiles    []ZipFile
-	stripprefix string
-	addprefix   string
-	indexname   string
-	dirredirect bool
-	headers     map[string]string
-	methodmap   map[string]map[uint16]int
-	rwlock      sync.RWMutex
-	accesslog   *slog.Logger
+	zipfiles      []ZipFile
+	stripprefix   string
+	addprefix     string
+	indexname     string
+	dirredirect   bool
+	headers       map[string]string
+	methodmap     map[string]map[uint16]int
+	rwlock        sync.RWMutex
+	accesslog     *slog.Logger
+	access        *AccessLogger
+	mmapopt       *MmapOption
+	nosendfile    bool
+	nosidecar     bool
+	pinopt        *PinOption
+	dynamic       *DynamicCompress
+	transcode     *TranscodeOption
+	transcoder    *Transcoder
+	imageopt      *ImageOption
+	langopt       *LangOption
+	meta          map[string]*ZipMeta
+	digests       *DigestCache
+	dictvariants  map[string][]dictVariant
+	earlyhints    bool
+	debug         *DebugOption
+	proxy         *ProxyOption
+	limit         *RateLimit
+	acl           *ACL
+	cors          []CORSRule
+	upstream      *Upstream
+	maintenance   *Maintenance
+	healthpaths   []string
+	readypaths    []string
+	draining      atomic.Bool
+	writeidle     time.Duration
+	lazydigest    bool
+	imagevariants map[string][]imageVariant
+	ctypes        map[int]string
 }
 
 type Encoding int
@@ -114,6 +214,8 @@ const (
 	EncodingIdentity
 	EncodingZstd
 	EncodingAny
+	EncodingDcb
+	EncodingDcz
 )
 
 var ErrNotModified = errors.New("not modified")
@@ -136,6 +238,10 @@ func (h *ZipHandler) accept_encoding(r *http.Request) Encoding {
 			res |= EncodingIdentity
 		case "zstd":
 			res |= EncodingZstd
+		case "dcb":
+			res |= EncodingDcb
+		case "dcz":
+			res |= EncodingDcz
 		case "*":
 			res |= EncodingAny
 		case "":
@@ -148,7 +254,7 @@ func (h *ZipHandler) accept_encoding(r *http.Request) Encoding {
 }
 
 func (h *ZipHandler) filename(r *http.Request) string {
-	fname := r.URL.Path
+	fname := clean_path(r.URL.Path)
 	fname = strings.TrimPrefix(fname, h.addprefix)
 	fname = h.stripprefix + fname
 	if strings.HasSuffix(fname, "/") {
@@ -167,9 +273,37 @@ func (h *ZipHandler) exists(path string) bool {
 	return false
 }
 
+type hitCounter interface {
+	hit(idx int)
+}
+
+type refCounted interface {
+	acquire()
+	release()
+}
+
+// acquire pins current archives and returns func to unpin them
+func (h *ZipHandler) acquire() func() {
+	pinned := make([]refCounted, 0)
+	for _, zf := range h.zipfiles {
+		if rc, ok := zf.(refCounted); ok {
+			rc.acquire()
+			pinned = append(pinned, rc)
+		}
+	}
+	return func() {
+		for _, rc := range pinned {
+			rc.release()
+		}
+	}
+}
+
 func (h *ZipHandler) getidx(idx int) *zip.File {
 	for _, zf := range h.zipfiles {
 		if idx < zf.Files() {
+			if hc, ok := zf.(hitCounter); ok {
+				hc.hit(idx)
+			}
 			return zf.File(idx)
 		}
 		idx -= zf.Files()
@@ -178,6 +312,38 @@ func (h *ZipHandler) getidx(idx int) *zip.File {
 	return nil
 }
 
+// getzip returns archive of idx and index in the archive
+func (h *ZipHandler) getzip(idx int) (ZipFile, int) {
+	for _, zf := range h.zipfiles {
+		if idx < zf.Files() {
+			return zf, idx
+		}
+		idx -= zf.Files()
+	}
+	return nil, -1
+}
+
+type sectionOpener interface {
+	OpenSection(idx int, fi *zip.File) (io.ReadCloser, error)
+}
+
+// openraw returns raw data of idx-th file, zero-copy if possible
+func (h *ZipHandler) openraw(idx int, fi *zip.File) (io.ReadCloser, error) {
+	zf, localidx := h.getzip(idx)
+	if so, ok := zf.(sectionOpener); ok && !h.nosendfile {
+		rd, err := so.OpenSection(localidx, fi)
+		if err == nil {
+			return rd, nil
+		}
+		slog.Warn("OpenSection", "name", fi.Name, "error", err)
+	}
+	rd, err := fi.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(rd), nil
+}
+
 func (h *ZipHandler) handle_pre(w http.ResponseWriter, r *http.Request, filemap map[uint16]int, method uint16, encoding string, addsz uint64, statuscode *int) (*zip.File, error) {
 	if idx, ok := filemap[method]; ok {
 		fi := h.getidx(idx)
@@ -190,40 +356,54 @@ func (h *ZipHandler) handle_pre(w http.ResponseWriter, r *http.Request, filemap
 			slog.Warn("encrypted", "name", fi.Name, "flag", fi.Flags)
 		}
 		// fast path
-		ctype := make_contenttype(fi.Comment)
-		if ctype == "" {
-			ctype = make_contentbyext(fi.Name)
-		}
-		if ctype != "" {
-			w.Header().Set("Content-Type", ctype)
-		}
-		for k, v := range h.headers {
-			w.Header().Set(k, v)
-		}
-		etag := "W/" + strconv.FormatUint(uint64(fi.CRC32), 16)
-		if conditional(r, etag, fi) {
-			*statuscode = http.StatusNotModified
-			w.Header().Add("Etag", etag)
-			w.Header().Add("Last-Modified", fi.Modified.Format(http.TimeFormat))
-			w.WriteHeader(*statuscode)
-			return nil, ErrNotModified
-		}
+		length := fi.UncompressedSize64
 		if encoding != "" {
-			slog.Debug("compressed response", "length", fi.CompressedSize64, "original", fi.UncompressedSize64, "encoding", encoding)
-			w.Header().Add("Content-Encoding", encoding)
-			w.Header().Add("Content-Length", strconv.FormatUint(fi.CompressedSize64+addsz, 10))
-		} else {
-			w.Header().Add("Content-Length", strconv.FormatUint(fi.UncompressedSize64, 10))
+			length = fi.CompressedSize64 + addsz
 		}
-		w.Header().Add("Last-Modified", fi.Modified.Format(http.TimeFormat))
-		if etag != "" {
-			w.Header().Add("Etag", etag)
+		if err := h.write_header(w, r, fi.Name, idx, fi, encoding, length, statuscode); err != nil {
+			return nil, err
 		}
 		return fi, nil
 	}
 	return nil, fmt.Errorf("not found")
 }
 
+// write_header sets response headers of fi with metadata and hints of fname, or responds 304 and returns ErrNotModified
+func (h *ZipHandler) write_header(w http.ResponseWriter, r *http.Request, fname string, idx int, fi *zip.File, encoding string, length uint64, statuscode *int) error {
+	h.debug_source(w, r, idx, fi, encoding)
+	ctype := h.contenttype(idx, fi)
+	if ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	for k, v := range h.headers {
+		w.Header().Set(k, v)
+	}
+	if meta, ok := h.meta[fname]; ok {
+		h.apply_meta(w, meta)
+	}
+	etag := h.etag(fi, encoding)
+	if conditional(r, etag, fi) {
+		*statuscode = http.StatusNotModified
+		w.Header().Add("Etag", etag)
+		w.Header().Add("Last-Modified", fi.Modified.Format(http.TimeFormat))
+		w.WriteHeader(*statuscode)
+		return ErrNotModified
+	}
+	h.send_hints(w, r, fname)
+	if encoding != "" {
+		slog.Debug("compressed response", "length", length, "original", fi.UncompressedSize64, "encoding", encoding)
+		w.Header().Add("Content-Encoding", encoding)
+	} else {
+		h.write_digest(w, r, fi)
+	}
+	w.Header().Add("Content-Length", strconv.FormatUint(length, 10))
+	w.Header().Add("Last-Modified", fi.Modified.Format(http.TimeFormat))
+	if etag != "" {
+		w.Header().Add("Etag", etag)
+	}
+	return nil
+}
+
 func (h *ZipHandler) handle_gzip(w http.ResponseWriter, r *http.Request, filemap map[uint16]int, statuscode *int) error {
 	fi, err := h.handle_pre(w, r, filemap, zip.Deflate, "gzip", GzipHeaderSize+GzipFooterSize, statuscode)
 	if err != nil {
@@ -248,15 +428,16 @@ func (h *ZipHandler) handle_raw(w http.ResponseWriter, r *http.Request, filemap
 		return err
 	}
 	if fi != nil {
-		rd, err := fi.OpenRaw()
+		rd, err := h.openraw(filemap[method], fi)
 		if err != nil {
 			slog.Error("OpenRaw", "name", fi.Name, "error", err)
 			*statuscode = http.StatusInternalServerError
 			return err
 		}
+		defer rd.Close()
 		*statuscode = http.StatusOK
 		w.WriteHeader(*statuscode)
-		if written, err := io.Copy(w, rd); err != nil {
+		if written, err := io.Copy(w, raw_source(rd)); err != nil {
 			slog.Error("copy", "written", written, "error", err)
 		} else {
 			slog.Debug("written", "written", written)
@@ -276,7 +457,12 @@ func (h *ZipHandler) handle_normal(w http.ResponseWriter, r *http.Request, filem
 		return err
 	}
 	if fi != nil {
-		rd, err := fi.Open()
+		var rd io.ReadCloser
+		if fi.Method == zip.Store {
+			rd, err = h.openraw(filemap[mtd], fi)
+		} else {
+			rd, err = fi.Open()
+		}
 		if err != nil {
 			slog.Error("Open", "name", fi.Name, "error", err)
 			*statuscode = http.StatusInternalServerError
@@ -285,7 +471,7 @@ func (h *ZipHandler) handle_normal(w http.ResponseWriter, r *http.Request, filem
 		defer rd.Close()
 		*statuscode = http.StatusOK
 		w.WriteHeader(*statuscode)
-		if written, err := io.Copy(w, rd); err != nil {
+		if written, err := io.Copy(w, raw_source(rd)); err != nil {
 			slog.Error("copy", "written", written, "error", err)
 		} else {
 			slog.Debug("written", "written", written)
@@ -320,6 +506,17 @@ func make_contentbyext(fname string) string {
 	return mime.TypeByExtension(filepath.Ext(fname))
 }
 
+func (h *ZipHandler) contenttype(idx int, fi *zip.File) string {
+	if ctype, ok := h.ctypes[idx]; ok {
+		return ctype
+	}
+	ctype := make_contenttype(fi.Comment)
+	if ctype == "" {
+		ctype = make_contentbyext(fi.Name)
+	}
+	return ctype
+}
+
 func (h *ZipHandler) send_raw(w http.ResponseWriter, r *http.Request, encodings Encoding, filebyenc map[uint16]int, fname string, statuscode *int) bool {
 	if encodings&EncodingBrotli != 0 {
 		slog.Debug("brotli encoding supported", "encodings", encodings)
@@ -360,55 +557,54 @@ func (h *ZipHandler) send_raw(w http.ResponseWriter, r *http.Request, encodings
 
 func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	statuscode := http.StatusOK
-	if h.accesslog != nil {
+	if h.writeidle > 0 {
+		w = newIdleWriter(w, h.writeidle)
+	}
+	r = h.resolve_client(r)
+	if h.limit != nil {
+		w = h.limit.throttle(w, r)
+	}
+	if h.debug != nil && h.debug.allowed(r) {
+		dw := newDebugWriter(w, r)
+		r = r.WithContext(context.WithValue(r.Context(), debugKey{}, dw))
+		defer dw.finish(r)
+		w = dw
+	}
+	source := ""
+	if h.accesslog != nil || h.access != nil {
 		start := time.Now()
 		defer func() {
-			headers := []any{
-				"remote", r.RemoteAddr, "elapsed", time.Since(start),
-				"method", r.Method, "path", r.URL.Path,
-				"status", statuscode, "protocol", r.Proto,
-			}
-			if r.URL.User.Username() != "" {
-				headers = append(headers, "user", r.URL.User.Username())
-			}
-			for k, v := range w.Header() {
-				switch strings.ToLower(k) {
-				case "etag", "content-type", "content-encoding", "location":
-					headers = append(headers, strings.ToLower(k), v[0])
-				case "content-length":
-					if val, err := strconv.Atoi(v[0]); err != nil {
-						headers = append(headers, "length", v[0])
-					} else {
-						headers = append(headers, "length", val)
-					}
-				case "last-modified":
-					if ts, err := time.Parse(http.TimeFormat, v[0]); err != nil {
-						headers = append(headers, "last-modified", v[0])
-					} else {
-						headers = append(headers, "last-modified", ts)
-					}
-				}
-			}
-			for k, v := range r.Header {
-				switch strings.ToLower(k) {
-				case "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto":
-					headers = append(headers, strings.TrimPrefix(strings.ToLower(k), "x-"), v[0])
-				case "forwarded", "user-agent", "if-none-match", "referer", "accept-encoding", "range":
-					headers = append(headers, strings.ToLower(k), v[0])
-				case "if-modified-since":
-					if ts, err := time.Parse(http.TimeFormat, v[0]); err != nil {
-						headers = append(headers, "if-modified-since", v[0])
-					} else {
-						headers = append(headers, "if-modified-since", ts)
-					}
-				}
+			h.log_access(w, r, statuscode, source, time.Since(start))
+		}()
+	}
+	if (len(h.healthpaths) != 0 || len(h.readypaths) != 0) && h.handle_health(w, r, &statuscode) {
+		return
+	}
+	if h.acl != nil && h.acl.deny_request(w, r, &statuscode) {
+		return
+	}
+	if h.limit != nil && h.limit.limit_request(w, r, &statuscode) {
+		return
+	}
+	if len(h.cors) != 0 && h.handle_cors(w, r, &statuscode) {
+		return
+	}
+	if h.upstream != nil {
+		source = "archive"
+		// upstream is called after RUnlock not to block reload
+		defer func() {
+			if source == "upstream" {
+				h.upstream.serve(w, r, &statuscode)
 			}
-			h.accesslog.Info(
-				http.StatusText(statuscode), headers...)
 		}()
 	}
 	h.rwlock.RLock()
 	defer h.rwlock.RUnlock()
+	defer h.acquire()()
+	if h.maintenance != nil && h.maintenance.active(r) {
+		h.send_maintenance(w, r, &statuscode)
+		return
+	}
 	fname := h.filename(r)
 	if h.dirredirect && !h.exists(fname) && h.exists(fname+"/"+h.indexname) {
 		statuscode = http.StatusMovedPermanently
@@ -417,6 +613,16 @@ func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(statuscode)
 		return
 	}
+	if h.langopt != nil {
+		var location string
+		if fname, location = h.negotiate_language(w, r, fname); location != "" {
+			statuscode = http.StatusFound
+			slog.Debug("language redirect", "url", r.URL, "location", location)
+			w.Header().Set("Location", location)
+			w.WriteHeader(statuscode)
+			return
+		}
+	}
 	if before, ok := strings.CutSuffix(fname, ".gz"); ok {
 		if idx, ok := h.methodmap[before][zip.Deflate]; ok {
 			slog.Debug("gzip file", "name", fname)
@@ -425,9 +631,8 @@ func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				slog.Error("cannot find", "idx", idx)
 				return
 			}
-			etag := "W/" + strconv.FormatUint(uint64(fi.CRC32), 16)
 			w.Header().Set("Content-Type", "application/gzip")
-			w.Header().Set("Etag", etag+"_gz")
+			w.Header().Set("Etag", h.etag(fi, "gz"))
 			written, err := CopyGzip(w, fi)
 			if err != nil {
 				slog.Error("copygzip", "error", err, "written", written)
@@ -436,18 +641,34 @@ func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	filebyenc, ok := h.methodmap[fname]
+	if h.imageopt != nil {
+		fname = h.negotiate_image(w, r, fname)
+	}
+	filebyenc, ok := h.methods(fname)
+	if (!ok || len(filebyenc) == 0) && h.upstream != nil {
+		source = "upstream"
+		return
+	}
 	if !ok || len(filebyenc) == 0 {
 		statuscode = http.StatusNotFound
 		w.WriteHeader(statuscode)
 		fmt.Fprint(w, "not found")
 		return
 	}
+	if h.redirect_meta(w, fname, &statuscode) {
+		return
+	}
 	encodings := h.accept_encoding(r)
 	slog.Debug("name", "uri", r.URL.Path, "name", fname)
+	if h.send_dictionary(w, r, encodings, fname, &statuscode) {
+		return
+	}
 	if h.send_raw(w, r, encodings, filebyenc, fname, &statuscode) {
 		return
 	}
+	if h.dynamic != nil && h.send_dynamic(w, r, encodings, filebyenc, fname, &statuscode) {
+		return
+	}
 	// fallback
 	var idx = -1
 	for _, v := range filebyenc {
@@ -470,17 +691,14 @@ func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// encrypted
 		slog.Warn("encrypted", "name", fname, "flag", fi.Flags)
 	}
-	ctype := make_contenttype(fi.Comment)
-	if ctype == "" {
-		ctype = make_contentbyext(fname)
-	}
+	ctype := h.contenttype(idx, fi)
 	if ctype != "" {
 		w.Header().Set("Content-Type", ctype)
 	}
 	for k, v := range h.headers {
 		w.Header().Set(k, v)
 	}
-	etag := "W/" + strconv.FormatUint(uint64(fi.CRC32), 16)
+	etag := h.etag(fi, "")
 	if conditional(r, etag, fi) {
 		statuscode = http.StatusNotModified
 		w.Header().Add("Etag", etag)
@@ -493,11 +711,89 @@ func (h *ZipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type namedZipFile interface {
+	Name() string
+}
+
+// headerReader has headers of entries without reading archive
+type headerReader interface {
+	FileHeader(idx int) *zip.FileHeader
+}
+
+// indexedZipFile has precomputed entries by sidecar
+type indexedZipFile interface {
+	Index() []ZipIndexEntry
+}
+
+// zip_header returns header of idx-th file in zf
+func zip_header(zf ZipFile, idx int) *zip.FileHeader {
+	if hr, ok := zf.(headerReader); ok {
+		return hr.FileHeader(idx)
+	}
+	if fi := zf.File(idx); fi != nil {
+		return &fi.FileHeader
+	}
+	return nil
+}
+
+// header_of returns header of idx-th file of inputs
+func header_of(inputs []ZipFile, idx int) *zip.FileHeader {
+	for _, zf := range inputs {
+		if idx < zf.Files() {
+			return zip_header(zf, idx)
+		}
+		idx -= zf.Files()
+	}
+	return nil
+}
+
+// methods returns methodmap entry of name, with transcoded ones. caller must hold read lock
+func (h *ZipHandler) methods(name string) (map[uint16]int, bool) {
+	bymethod, ok := h.methodmap[name]
+	if ok && h.transcoder != nil {
+		bymethod = h.transcoder.methods(name, bymethod)
+	}
+	return bymethod, ok
+}
+
+// file_of returns idx-th file of inputs without counting hits
+func file_of(inputs []ZipFile, idx int) *zip.File {
+	for _, zf := range inputs {
+		if idx < zf.Files() {
+			return zf.File(idx)
+		}
+		idx -= zf.Files()
+	}
+	return nil
+}
+
 func (h *ZipHandler) init2(inputs []ZipFile) {
 	methodmap := make(map[string]map[uint16]int, 0)
+	ctypes := make(map[int]string, 0)
+	digests := make(map[string]string, 0)
 	var cur = 0
 	count := make(map[uint16]int, 0)
 	for _, input := range inputs {
+		if indexed, ok := input.(indexedZipFile); ok && indexed.Index() != nil {
+			for _, ent := range indexed.Index() {
+				if strings.Contains(ent.Name, "..") || strings.HasSuffix(ent.Name, "/") {
+					continue
+				}
+				count[ent.Method]++
+				if _, ok := methodmap[ent.Name]; !ok {
+					methodmap[ent.Name] = make(map[uint16]int, 0)
+				}
+				if _, ok := methodmap[ent.Name][ent.Method]; !ok {
+					methodmap[ent.Name][ent.Method] = cur + ent.Index
+					ctypes[cur+ent.Index] = ent.ContentType
+				}
+				if ent.Digest != "" {
+					digests[ent.Name] = ent.Digest
+				}
+			}
+			cur += input.Files()
+			continue
+		}
 		for i := 0; i < input.Files(); i++ {
 			fi := input.File(i)
 			offset, err := fi.DataOffset()
@@ -529,15 +825,7 @@ func (h *ZipHandler) init2(inputs []ZipFile) {
 	for fname, bymethod := range methodmap {
 		var crc32 uint32 = 0
 		for method, idx := range bymethod {
-			fi := func(idx int, inputs []ZipFile) *zip.File {
-				for _, zf := range inputs {
-					if idx < zf.Files() {
-						return zf.File(idx)
-					}
-					idx -= zf.Files()
-				}
-				return nil
-			}(idx, inputs)
+			fi := header_of(inputs, idx)
 			if fi == nil {
 				slog.Error("not found", "name", fname, "idx", idx)
 			}
@@ -549,8 +837,17 @@ func (h *ZipHandler) init2(inputs []ZipFile) {
 		}
 	}
 	slog.Info("by method", "count", count)
+	dictvariants := dictionary_variants(methodmap, ctypes, inputs)
+	meta := load_meta(methodmap, func(idx int) *zip.FileHeader { return header_of(inputs, idx) })
+	for name, m := range meta {
+		if m.Digest != "" {
+			digests[name] = m.Digest
+		}
+	}
 	h.rwlock.Lock()
 	defer h.rwlock.Unlock()
+	// workers read old archives. they do not take handler lock, so they are stopped in it
+	h.stop_transcode()
 	for _, v := range h.zipfiles {
 		if err := v.Close(); err != nil {
 			slog.Error("close zipfile", "error", err)
@@ -559,35 +856,94 @@ func (h *ZipHandler) init2(inputs []ZipFile) {
 	}
 	h.zipfiles = inputs
 	h.methodmap = methodmap
+	h.ctypes = ctypes
+	h.meta = meta
+	h.dictvariants = dictvariants
+	h.digests = NewDigestCache(digests, h.lazydigest)
+	if h.imageopt != nil {
+		h.imagevariants = h.imageopt.image_variants(methodmap)
+	}
+	h.start_transcode()
 }
 
-func (h *ZipHandler) initialize_memory(input [][]byte) error {
+func (h *ZipHandler) initialize_memory(input [][]byte, names ...string) error {
 	zipfiles := make([]ZipFile, 0)
-	for _, v := range input {
+	for i, v := range input {
 		zipfile, err := NewZipFileBytes(v)
 		if err != nil {
 			return err
 		}
+		if i < len(names) {
+			zipfile.name = names[i]
+		}
 		zipfiles = append(zipfiles, zipfile)
 	}
 	h.init2(zipfiles)
 	return nil
 }
 
+// hybrid wraps zipfile to pin entries in memory if configured
+func (h *ZipHandler) hybrid(zipfile ZipFile) ZipFile {
+	if h.pinopt == nil {
+		return zipfile
+	}
+	return NewZipFileHybrid(zipfile, *h.pinopt)
+}
+
+// open_file opens archive by sidecar if it is up to date
+func (h *ZipHandler) open_file(name string) (ZipFile, error) {
+	if !h.nosidecar {
+		zipfile, err := NewZipFileIndexed(name)
+		if err == nil {
+			slog.Info("use sidecar", "name", name, "entries", zipfile.Files())
+			return zipfile, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("ignore sidecar", "name", name, "error", err)
+		}
+	}
+	return NewZipFileFile(name)
+}
+
 func (h *ZipHandler) initialize_file(input []string) error {
 	zipfiles := make([]ZipFile, 0)
 	for _, v := range input {
-		zipfile, err := NewZipFileFile(v)
+		zipfile, err := h.open_file(v)
 		if err != nil {
 			return err
 		}
-		zipfiles = append(zipfiles, zipfile)
+		zipfiles = append(zipfiles, h.hybrid(zipfile))
+	}
+	h.init2(zipfiles)
+	return nil
+}
+
+func (h *ZipHandler) initialize_mmap(input []string) error {
+	zipfiles := make([]ZipFile, 0)
+	for _, v := range input {
+		zipfile, err := NewZipFileMmap(v, *h.mmapopt)
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Warn("mmap not supported, fallback to file", "file", v)
+			zipfile, err := h.open_file(v)
+			if err != nil {
+				return err
+			}
+			zipfiles = append(zipfiles, h.hybrid(zipfile))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		zipfiles = append(zipfiles, h.hybrid(zipfile))
 	}
 	h.init2(zipfiles)
 	return nil
 }
 
 func (h *ZipHandler) Close() error {
+	h.rwlock.Lock()
+	defer h.rwlock.Unlock()
+	h.stop_transcode()
 	for _, v := range h.zipfiles {
 		if v != nil {
 			if err := v.Close(); err != nil {
@@ -625,7 +981,12 @@ func (h *ZipHandler) initialize(filenames []string, inmemory bool) error {
 			bufs = append(bufs, buf)
 			slog.Debug("memory size", "file", filenames, "size", len(buf))
 		}
-		if err := h.initialize_memory(bufs); err != nil {
+		if err := h.initialize_memory(bufs, filenames...); err != nil {
+			slog.Error("initialize failed", "err", err)
+			return err
+		}
+	} else if h.mmapopt != nil {
+		if err := h.initialize_mmap(filenames); err != nil {
 			slog.Error("initialize failed", "err", err)
 			return err
 		}
@@ -641,14 +1002,25 @@ func (h *ZipHandler) initialize(filenames []string, inmemory bool) error {
 func do_listen(listen string) (net.Listener, error) {
 	protos := strings.SplitN(listen, ":", 2)
 	switch protos[0] {
-	case "unix", "tcp", "tcp4", "tcp6":
+	case "unix":
+		remove_stale_socket(protos[1])
+		return net.Listen(protos[0], protos[1])
+	case "tcp", "tcp4", "tcp6":
 		return net.Listen(protos[0], protos[1])
 	}
 	return net.Listen("tcp", listen)
 }
 
 type WebServer struct {
-	Listen            string           `short:"l" long:"listen" default:":3000" description:"listen address:port"`
+	Listen            []string         `short:"l" long:"listen" default:":3000" description:"listen address:port, or unix:/path/to/socket. can be repeated. ignored by systemd socket activation"`
+	SocketMode        string           `long:"socket-mode" description:"file mode of unix socket(e.g. 0660)"`
+	SocketOwner       string           `long:"socket-owner" description:"owner of unix socket(user, user:group or :group)"`
+	User              string           `long:"user" description:"switch to this user after listen"`
+	Group             string           `long:"group" description:"switch to this group after listen (default: primary group of --user)"`
+	Chroot            string           `long:"chroot" description:"chroot to this directory after listen"`
+	Landlock          bool             `long:"landlock" description:"restrict filesystem access to archive directories by Landlock(Linux)"`
+	LandlockRead      []string         `long:"landlock-read" description:"additional readable path of --landlock"`
+	LandlockWrite     []string         `long:"landlock-write" description:"additional writable path of --landlock"`
 	AltZipName        []flags.Filename `long:"add" description:"add zip name"`
 	IndexFilename     string           `long:"index" description:"index filename" default:"index.html"`
 	DirRedirect       bool             `long:"directory-redirect" description:"auto redirect when missing '/'"`
@@ -657,19 +1029,127 @@ type WebServer struct {
 	ReadTimeout       time.Duration    `long:"read-timeout" default:"10s"`
 	ReadHeaderTimeout time.Duration    `long:"read-header-timeout" default:"10s"`
 	WriteTimeout      time.Duration    `long:"write-timeout" default:"30s"`
+	WriteIdleTimeout  time.Duration    `long:"write-idle-timeout" description:"timeout of each write instead of --write-timeout for whole response, 0 to disable"`
+	DrainTimeout      time.Duration    `long:"drain-timeout" description:"wait in-flight requests at shutdown, then close forcibly. 0 to wait forever" default:"30s"`
+	DrainDelay        time.Duration    `long:"drain-delay" description:"keep accepting requests after readiness turns 503 at shutdown, for load balancers to notice"`
 	IdleTimeout       time.Duration    `long:"idle-timeout" default:"10s"`
 	InMemory          bool             `long:"in-memory" description:"load zip to memory"`
+	Mmap              bool             `long:"mmap" description:"map zip to memory"`
+	MmapAdvise        string           `long:"mmap-advise" choice:"normal" choice:"random" choice:"sequential" choice:"willneed" default:"normal" description:"madvise for mapped zip"`
+	MmapPrewarm       bool             `long:"mmap-prewarm" description:"read mapped zip to warm page cache"`
+	NoSendfile        bool             `long:"no-sendfile" description:"do not use sendfile for raw data"`
+	NoSidecar         bool             `long:"no-sidecar" description:"do not use index sidecar(<archive>.idx)"`
+	PinBudget         int64            `long:"pin-budget" description:"memory budget(bytes) to pin entries, 0 to disable"`
+	PinSize           uint64           `long:"pin-size" description:"pin entries compressed smaller than this" default:"4096"`
+	PinPatterns       []string         `long:"pin" description:"pin entries match patterns"`
+	PinHits           uint32           `long:"pin-hits" description:"pin entries accessed this times, 0 to disable"`
+	DynamicCompress   bool             `long:"dynamic-compress" description:"compress stored entries on-the-fly"`
+	DynamicCache      int64            `long:"dynamic-cache" description:"cache size(bytes) of on-the-fly compression" default:"67108864"`
+	DynamicTypes      []string         `long:"dynamic-type" description:"content types to compress on-the-fly" default:"text/*" default:"application/json" default:"application/javascript" default:"application/xml" default:"image/svg+xml"`
+	DynamicMinSize    uint64           `long:"dynamic-min-size" description:"minimum size to compress on-the-fly" default:"256"`
+	DynamicMaxSize    uint64           `long:"dynamic-max-size" description:"maximum size to compress on-the-fly" default:"8388608"`
+	Transcode         []string         `long:"transcode" choice:"br" choice:"zstd" description:"transcode deflate entries in background"`
+	TranscodeWorkers  int              `long:"transcode-workers" description:"number of transcode workers" default:"2"`
+	TranscodeLevel    int              `long:"transcode-level" description:"compression level of transcode" default:"-1"`
+	TranscodeDir      string           `long:"transcode-dir" description:"cache directory of transcoded entries"`
+	ImageFormats      []string         `long:"image-format" description:"serve image variants in order of preference by Accept header(e.g. avif, webp)"`
+	ImageNaming       string           `long:"image-naming" description:"name of image variant. {name}, {base} and {ext} are replaced" default:"{name}.{ext}"`
+	Languages         []string         `long:"lang" description:"available languages of localized pages(index.en.html or en/index.html)"`
+	LangDefault       string           `long:"lang-default" description:"default language (default: first of --lang)"`
+	LangCookie        string           `long:"lang-cookie" description:"cookie name to override language"`
+	LangQuery         string           `long:"lang-query" description:"query parameter to override language"`
+	LangRedirect      bool             `long:"lang-redirect" description:"redirect / to language prefix"`
+	EarlyHints        bool             `long:"early-hints" description:"send 103 Early Hints of preload links in archive"`
+	DebugAllow        []string         `long:"debug-allow" description:"client CIDR to annotate responses with Server-Timing and X-Ziphttp-Source"`
+	DebugSecret       string           `long:"debug-secret" description:"annotate responses if X-Ziphttp-Debug header has this value" secret:"true"`
+	LazyDigest        bool             `long:"lazy-digest" description:"compute SHA-256 of entries on first access for strong ETag and Repr-Digest"`
+	TrustedProxy      []string         `long:"trusted-proxy" description:"CIDR of reverse proxy to trust Forwarded, X-Forwarded-For and X-Forwarded-Proto"`
+	ProxyProtocol     bool             `long:"proxy-protocol" description:"accept PROXY protocol v1/v2 from --trusted-proxy"`
+	ACLFile           flags.Filename   `long:"acl" description:"JSON file of allowed/denied CIDR by path prefix or glob, reloaded by SIGHUP"`
+	CORSFile          flags.Filename   `long:"cors" description:"JSON file of CORS policy by path prefix or glob"`
+	CORSOrigin        []string         `long:"cors-origin" description:"allowed origin of all paths, exact or pattern(https://*.example.com)"`
+	Upstream          string           `long:"upstream" description:"proxy paths not in archive to this URL(http://host:port) or unix:/path/to/socket"`
+	UpstreamDial      time.Duration    `long:"upstream-dial-timeout" description:"connect timeout of upstream" default:"5s"`
+	UpstreamTimeout   time.Duration    `long:"upstream-timeout" description:"response header timeout of upstream" default:"30s"`
+	Maintenance       bool             `long:"maintenance" description:"start in maintenance mode. toggled by SIGUSR2 or admin endpoint"`
+	MaintenanceFile   string           `long:"maintenance-file" description:"maintenance mode while this file exists"`
+	MaintenancePage   string           `long:"maintenance-page" description:"page in archive to respond in maintenance mode" default:"maintenance.html"`
+	MaintenanceRetry  time.Duration    `long:"maintenance-retry-after" description:"Retry-After of maintenance mode" default:"5m"`
+	MaintenancePaths  []string         `long:"maintenance-allow-path" description:"path prefix or glob to serve in maintenance mode"`
+	MaintenanceAllow  []string         `long:"maintenance-allow" description:"client CIDR to serve in maintenance mode"`
+	HealthPath        []string         `long:"health-path" description:"path to respond 200 for health check, served before other rules"`
+	ReadyPath         []string         `long:"ready-path" description:"path to respond 200 for readiness check, 503 while shutting down"`
+	AdminListen       string           `long:"admin-listen" description:"listen address of admin endpoint(/healthz, /maintenance)"`
+	RateLimit         float64          `long:"rate-limit" description:"requests per second of each client, 0 to disable"`
+	RateBurst         int              `long:"rate-burst" description:"burst requests of each client (default: --rate-limit)"`
+	RateLimitPath     []string         `long:"rate-limit-path" description:"requests per second of each client under path prefix (PREFIX=RATE[/BURST])"`
+	Bandwidth         int64            `long:"bandwidth" description:"total bandwidth(bytes/sec) of responses, 0 to disable"`
+	BandwidthPerConn  int64            `long:"bandwidth-per-conn" description:"bandwidth(bytes/sec) of each connection, 0 to disable"`
+	LimitStats        time.Duration    `long:"limit-stats-interval" description:"interval to log limiter statistics, 0 to disable"`
+	AccessLog         string           `long:"access-log" description:"access log destination(stderr, stdout, syslog, syslog:udp:host:port or filename)"`
+	AccessLogFormat   string           `long:"access-log-format" choice:"slog" choice:"json" choice:"common" choice:"combined" choice:"template" default:"slog" description:"access log format"`
+	AccessLogTemplate string           `long:"access-log-template" description:"text/template of access log for --access-log-format=template"`
+	AccessLogMaxSize  int64            `long:"access-log-rotate-size" description:"rotate access log file larger than this(bytes), 0 to disable"`
+	AccessLogInterval time.Duration    `long:"access-log-rotate-interval" description:"rotate access log file by this interval, 0 to disable"`
+	AccessLogSample   float64          `long:"access-log-sample" description:"ratio of requests to log(0 < ratio < 1), 0 to log all"`
+	AccessLogExclude  []string         `long:"access-log-exclude" description:"do not log requests of path match pattern(e.g. /healthz)"`
 	Headers           []string         `short:"H" long:"header" description:"custom response headers"`
 	AutoReload        bool             `long:"autoreload" description:"detect zip file change and reload"`
 	SupportGzip       bool             `long:"support-gz" description:"support *.gz URL"`
 	OpenTelemetry     bool             `long:"opentelemetry" description:"otel trace setup"`
 	server            http.Server
+	admin             http.Server
+	conns             *connTracker
+	drain             sync.WaitGroup
 	handler           ZipHandler
+	chrooted          bool
+	archives          []string
+}
+
+// parse_headers parses "Name: value" of --header
+func parse_headers(hdrs []string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, hdr := range hdrs {
+		kv := strings.SplitN(hdr, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header: %s", hdr)
+		}
+		res[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	return res, nil
+}
+
+// validate checks options before opening archive and listeners
+func (cmd *WebServer) validate() error {
+	if _, err := parse_headers(cmd.Headers); err != nil {
+		return err
+	}
+	if cmd.ProxyProtocol && len(cmd.TrustedProxy) == 0 {
+		return errors.New("--proxy-protocol requires --trusted-proxy")
+	}
+	if cmd.AccessLogFormat == "template" && cmd.AccessLogTemplate == "" {
+		return errors.New("--access-log-template is required")
+	}
+	for name, cidrs := range map[string][]string{"debug-allow": cmd.DebugAllow, "trusted-proxy": cmd.TrustedProxy, "maintenance-allow": cmd.MaintenanceAllow} {
+		if _, err := parse_networks(cidrs); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	for _, pattern := range cmd.MaintenancePaths {
+		if err := check_path_pattern(pattern); err != nil {
+			return fmt.Errorf("invalid maintenance-allow-path: %w", err)
+		}
+	}
+	return nil
 }
 
 func (cmd *WebServer) Execute(args []string) (err error) {
 	init_log()
 	slog.Info("args", "args", args)
+	if err = cmd.validate(); err != nil {
+		slog.Error("invalid option", "error", err)
+		return err
+	}
 	cmd.handler = ZipHandler{
 		zipfiles:    make([]ZipFile, 0),
 		stripprefix: cmd.StripPrefix,
@@ -677,8 +1157,127 @@ func (cmd *WebServer) Execute(args []string) (err error) {
 		indexname:   cmd.IndexFilename,
 		dirredirect: cmd.DirRedirect,
 		methodmap:   make(map[string]map[uint16]int),
-		headers:     make(map[string]string),
 		accesslog:   slog.With("type", "accesslog"),
+		nosendfile:  cmd.NoSendfile,
+		nosidecar:   cmd.NoSidecar,
+		lazydigest:  cmd.LazyDigest,
+		earlyhints:  cmd.EarlyHints,
+	}
+	if cmd.PinBudget > 0 {
+		cmd.handler.pinopt = &PinOption{Budget: cmd.PinBudget, MaxSize: cmd.PinSize, Patterns: cmd.PinPatterns, Hits: cmd.PinHits}
+	}
+	if cmd.DynamicCompress {
+		cmd.handler.dynamic = &DynamicCompress{
+			cache:   NewDynamicCache(cmd.DynamicCache),
+			types:   cmd.DynamicTypes,
+			minsize: cmd.DynamicMinSize,
+			maxsize: cmd.DynamicMaxSize,
+		}
+	}
+	if len(cmd.Transcode) != 0 {
+		cmd.handler.transcode = &TranscodeOption{Encodings: cmd.Transcode, Workers: cmd.TranscodeWorkers, Level: cmd.TranscodeLevel, CacheDir: cmd.TranscodeDir}
+	}
+	if len(cmd.ImageFormats) != 0 {
+		cmd.handler.imageopt = &ImageOption{Formats: cmd.ImageFormats, Naming: cmd.ImageNaming}
+	}
+	if len(cmd.Languages) != 0 {
+		cmd.handler.langopt = &LangOption{Languages: cmd.Languages, Default: cmd.LangDefault, Cookie: cmd.LangCookie, Query: cmd.LangQuery, Redirect: cmd.LangRedirect}
+		if cmd.handler.langopt.Default == "" {
+			cmd.handler.langopt.Default = cmd.Languages[0]
+		}
+	}
+	if len(cmd.DebugAllow) != 0 || cmd.DebugSecret != "" {
+		networks, err := parse_networks(cmd.DebugAllow)
+		if err != nil {
+			slog.Error("invalid debug-allow", "allow", cmd.DebugAllow, "error", err)
+			return err
+		}
+		cmd.handler.debug = &DebugOption{Networks: networks, Secret: cmd.DebugSecret}
+	}
+	if len(cmd.TrustedProxy) != 0 {
+		networks, err := parse_networks(cmd.TrustedProxy)
+		if err != nil {
+			slog.Error("invalid trusted-proxy", "proxy", cmd.TrustedProxy, "error", err)
+			return err
+		}
+		cmd.handler.proxy = &ProxyOption{Networks: networks}
+	}
+	if cmd.ACLFile != "" {
+		if cmd.handler.acl, err = NewACL(string(cmd.ACLFile)); err != nil {
+			slog.Error("acl", "name", cmd.ACLFile, "error", err)
+			return err
+		}
+	}
+	if cmd.CORSFile != "" {
+		if cmd.handler.cors, err = LoadCORSRules(string(cmd.CORSFile)); err != nil {
+			slog.Error("cors", "name", cmd.CORSFile, "error", err)
+			return err
+		}
+	}
+	if len(cmd.CORSOrigin) != 0 {
+		cmd.handler.cors = append(cmd.handler.cors, CORSRule{Path: "/", Origins: cmd.CORSOrigin})
+	}
+	if cmd.Upstream != "" {
+		cmd.handler.upstream, err = NewUpstream(UpstreamOption{URL: cmd.Upstream, DialTimeout: cmd.UpstreamDial, ResponseTimeout: cmd.UpstreamTimeout})
+		if err != nil {
+			slog.Error("invalid upstream", "upstream", cmd.Upstream, "error", err)
+			return err
+		}
+	}
+	maintallow, err := parse_networks(cmd.MaintenanceAllow)
+	if err != nil {
+		slog.Error("invalid maintenance-allow", "allow", cmd.MaintenanceAllow, "error", err)
+		return err
+	}
+	cmd.handler.maintenance = NewMaintenance(MaintenanceOption{
+		Page:       cmd.MaintenancePage,
+		RetryAfter: cmd.MaintenanceRetry,
+		Paths:      cmd.MaintenancePaths,
+		Networks:   maintallow,
+		FlagFile:   cmd.MaintenanceFile,
+	}, cmd.Maintenance)
+	if cmd.MaintenanceFile != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go cmd.handler.maintenance.watch_file(ctx, time.Second)
+	}
+	cmd.handler.healthpaths = cmd.HealthPath
+	cmd.handler.readypaths = cmd.ReadyPath
+	cmd.handler.writeidle = cmd.WriteIdleTimeout
+	if cmd.RateLimit > 0 || len(cmd.RateLimitPath) != 0 || cmd.Bandwidth > 0 || cmd.BandwidthPerConn > 0 {
+		cmd.handler.limit, err = NewRateLimit(cmd.RateLimit, cmd.RateBurst, cmd.RateLimitPath, cmd.Bandwidth, cmd.BandwidthPerConn)
+		if err != nil {
+			slog.Error("invalid rate limit", "error", err)
+			return err
+		}
+		if cmd.LimitStats > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go cmd.handler.limit.log_stats(ctx, cmd.LimitStats)
+		}
+	}
+	if cmd.AccessLog != "" || cmd.AccessLogFormat != "slog" || cmd.AccessLogSample != 0 || len(cmd.AccessLogExclude) != 0 {
+		access, err := NewAccessLogger(AccessLogOption{
+			Format:   cmd.AccessLogFormat,
+			Template: cmd.AccessLogTemplate,
+			Output:   cmd.AccessLog,
+			MaxSize:  cmd.AccessLogMaxSize,
+			Interval: cmd.AccessLogInterval,
+			Sample:   cmd.AccessLogSample,
+			Exclude:  cmd.AccessLogExclude,
+		})
+		if err != nil {
+			slog.Error("access log", "output", cmd.AccessLog, "error", err)
+			return err
+		}
+		defer access.Close()
+		cmd.handler.access = access
+		if cmd.AccessLog != "" || cmd.AccessLogFormat == "json" {
+			cmd.handler.accesslog = access.logger()
+		}
+	}
+	if cmd.Mmap {
+		cmd.handler.mmapopt = &MmapOption{Advise: cmd.MmapAdvise, Prewarm: cmd.MmapPrewarm}
 	}
 	files := make([]string, 0)
 	files = append(files, archiveFilename())
@@ -691,37 +1290,35 @@ func (cmd *WebServer) Execute(args []string) (err error) {
 	}
 	defer cmd.handler.Close()
 	slog.Info("open success", "files", len(cmd.handler.methodmap), "archives", len(files))
-	for _, hdr := range cmd.Headers {
-		if kv := strings.SplitN(hdr, ":", 2); len(kv) != 2 {
-			slog.Error("invalid header spec", "header", hdr)
-			return fmt.Errorf("invalid header: %s", hdr)
-		} else {
-			cmd.handler.headers[kv[0]] = strings.TrimSpace(kv[1])
-		}
-	}
+	// validated
+	cmd.handler.headers, _ = parse_headers(cmd.Headers)
 	cmd.server = http.Server{
-		Handler:           nil,
 		ReadTimeout:       cmd.ReadTimeout,
 		ReadHeaderTimeout: cmd.ReadHeaderTimeout,
 		WriteTimeout:      cmd.WriteTimeout,
 		IdleTimeout:       cmd.IdleTimeout,
 		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
 	}
+	if cmd.WriteIdleTimeout > 0 {
+		cmd.server.WriteTimeout = 0
+	}
+	cmd.conns = newConnTracker()
+	cmd.server.ConnState = cmd.conns.track
+	cmd.server.ConnContext = cmd.conn_context
+	var handler http.Handler = &cmd.handler
 	if cmd.OpenTelemetry {
-		stop, handler, err := cmd.init_otel(&cmd.handler, "ziphttp")
+		stop, otelhandler, err := cmd.init_otel(&cmd.handler, "ziphttp")
 		if err != nil {
 			slog.Warn("opentelemetry initialize failed", "error", err)
-			http.Handle("/", &cmd.handler)
 		} else {
 			defer stop()
-			http.Handle("/", handler)
+			handler = otelhandler
 		}
-	} else {
-		http.Handle("/", &cmd.handler)
 	}
+	cmd.server.Handler = public_handler(handler)
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(sigs, slices.Concat([]os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}, reopenSignals, maintenanceSignals)...)
 
 	go func() {
 		var err error
@@ -730,15 +1327,26 @@ func (cmd *WebServer) Execute(args []string) (err error) {
 			slog.Info("caught signal", "signal", sig)
 			switch sig {
 			case syscall.SIGHUP:
+				if err = cmd.reload_config(); err != nil {
+					slog.Error("config reload failed, keep current options", "name", globalOption.Config, "error", err)
+				}
 				if err = cmd.Reload(); err != nil {
-					slog.Error("reload failed", "error", err)
-					return
+					slog.Error("reload failed, keep current archive", "error", err)
 				}
 			case syscall.SIGINT, syscall.SIGTERM:
 				if err = cmd.Shutdown(); err != nil {
 					slog.Error("terminate failed", "error", err)
 				}
 				return
+			default:
+				if slices.Contains(reopenSignals, sig) && cmd.handler.access != nil {
+					if err = cmd.handler.access.Reopen(); err != nil {
+						slog.Error("reopen access log failed", "error", err)
+					}
+				}
+				if slices.Contains(maintenanceSignals, sig) {
+					cmd.handler.maintenance.Toggle()
+				}
 			}
 		}
 	}()
@@ -780,32 +1388,122 @@ func (cmd *WebServer) Execute(args []string) (err error) {
 		}
 	}
 
-	listener, err := do_listen(cmd.Listen)
+	listeners, err := cmd.open_listeners()
 	if err != nil {
 		slog.Error("listen error", "error", err)
 		return err
 	}
-	slog.Info("server starting", "listen", listener.Addr(), "pid", os.Getpid())
-	err = cmd.server.Serve(listener)
-	if err != nil && err != http.ErrServerClosed {
-		slog.Error("listen error", "error", err)
-		return err
+	if cmd.ProxyProtocol {
+		for i := range listeners {
+			listeners[i] = &proxyListener{Listener: listeners[i], networks: cmd.handler.proxy.Networks, timeout: cmd.ReadHeaderTimeout}
+		}
+	}
+	if cmd.AdminListen != "" {
+		if err = cmd.start_admin(); err != nil {
+			slog.Error("admin listen error", "error", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		defer cmd.admin.Close()
+	}
+	if priv := (PrivOption{User: cmd.User, Group: cmd.Group, Chroot: cmd.Chroot, Landlock: cmd.Landlock}); priv.enabled() {
+		priv.ReadPaths, priv.WritePaths = cmd.sandbox_paths(files)
+		var root, workdir string
+		if cmd.Chroot != "" {
+			// archive cannot be reopened by name for sendfile
+			cmd.handler.nosendfile = true
+			root, _ = filepath.Abs(cmd.Chroot)
+			workdir, _ = os.Getwd()
+		}
+		if err = drop_privileges(priv); err != nil {
+			slog.Error("drop privileges", "error", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		if cmd.Chroot != "" {
+			cmd.enter_chroot(root, workdir, files)
+		}
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		slog.Info("server starting", "listen", listener.Addr(), "pid", os.Getpid())
+		go func() {
+			errs <- cmd.server.Serve(listener)
+		}()
+	}
+	var failed error
+	for range listeners {
+		if err = <-errs; err != nil && err != http.ErrServerClosed && failed == nil {
+			slog.Error("listen error", "error", err)
+			failed = err
+			cmd.server.Close()
+		}
+	}
+	if failed != nil {
+		return failed
 	}
+	// Serve returns immediately by Shutdown. wait for draining
+	cmd.drain.Wait()
 	slog.Info("server closed", "msg", err)
 	return nil
 }
 
+// conn_context passes PROXY protocol and bandwidth of each connection to handler
+func (cmd *WebServer) conn_context(ctx context.Context, conn net.Conn) context.Context {
+	ctx = proxy_context(ctx, conn)
+	if cmd.handler.limit != nil {
+		ctx = cmd.handler.limit.bandwidth_context(ctx, conn)
+	}
+	return ctx
+}
+
 func (cmd *WebServer) Shutdown() error {
-	slog.Info("graceful shutdown")
-	return cmd.server.Shutdown(context.TODO())
+	cmd.drain.Add(1)
+	defer cmd.drain.Done()
+	cmd.handler.draining.Store(true)
+	if cmd.DrainDelay > 0 {
+		slog.Info("wait before shutdown", "delay", cmd.DrainDelay)
+		time.Sleep(cmd.DrainDelay)
+	}
+	active, idle := cmd.conns.counts()
+	slog.Info("graceful shutdown", "active", active, "idle", idle, "timeout", cmd.DrainTimeout)
+	ctx := context.Background()
+	if cmd.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.DrainTimeout)
+		defer cancel()
+	}
+	err := cmd.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		active, idle = cmd.conns.counts()
+		slog.Warn("drain timeout, close connections", "active", active, "idle", idle)
+		return cmd.server.Close()
+	}
+	return err
 }
 
 func (cmd *WebServer) Reload() error {
 	files := make([]string, 0)
-	files = append(files, archiveFilename())
-	for _, fn := range cmd.AltZipName {
-		files = append(files, string(fn))
+	if cmd.chrooted {
+		if cmd.archives == nil {
+			return errors.New("archive is outside of chroot, restart to reload")
+		}
+		files = append(files, cmd.archives...)
+	} else {
+		files = append(files, archiveFilename())
+		for _, fn := range cmd.AltZipName {
+			files = append(files, string(fn))
+		}
+	}
+	if cmd.handler.acl != nil {
+		if err := cmd.handler.acl.Reload(); err != nil {
+			slog.Error("acl reload failed, keep current rules", "name", cmd.ACLFile, "error", err)
+		}
 	}
-	slog.Info("reloading archive", "name", files, "inmemory", cmd.InMemory)
+	slog.Info("reloading archive", "name", files, "inmemory", cmd.InMemory, "mmap", cmd.Mmap)
 	return cmd.handler.initialize(files, cmd.InMemory)
 }
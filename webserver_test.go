@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"bytes"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -369,6 +370,14 @@ func TestFilename(t *testing.T) {
 			path:        "/static/docs//a.txt",
 			expected:    "root/docs/a.txt",
 		},
+		{
+			name:        "resolve dot segments",
+			stripprefix: "",
+			addprefix:   "",
+			indexname:   "index.html",
+			path:        "/./a/../b.txt",
+			expected:    "b.txt",
+		},
 		{
 			name:        "root with index",
 			stripprefix: "",
@@ -422,6 +431,31 @@ func TestDirectoryRedirect(t *testing.T) {
 	}
 }
 
+func TestPublicHandlerCleanPath(t *testing.T) {
+	t.Parallel()
+	hdl := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+	}
+	if err := hdl.initialize_memory([][]byte{testzip}, "test.zip"); err != nil {
+		t.Error("initialize", err)
+		return
+	}
+	handler := public_handler(&hdl)
+	for _, p := range []string{"//4kb.txt", "/./4kb.txt", "/x/../4kb.txt"} {
+		req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/", bytes.NewBuffer([]byte{}))
+		req.URL.Path = p
+		got := httptest.NewRecorder()
+		handler.ServeHTTP(got, req)
+		if got.Code/100 != 3 {
+			t.Error("status", p, got.Code)
+		}
+		if loc := got.Result().Header.Get("Location"); loc != "/4kb.txt" {
+			t.Error("location", p, loc)
+		}
+	}
+}
+
 func TestHeaderInjection(t *testing.T) {
 	t.Parallel()
 	hdl := ZipHandler{
@@ -731,7 +765,7 @@ func TestWebServerExecuteInitializeError(t *testing.T) {
 	globalOption.Self = false
 	globalOption.Archive = flags.Filename("/not/found/archive.zip")
 
-	cmd := WebServer{Listen: "127.0.0.1:0"}
+	cmd := WebServer{Listen: []string{"127.0.0.1:0"}}
 	if err := cmd.Execute(nil); err == nil {
 		t.Error("expected initialize error")
 	}
@@ -748,7 +782,7 @@ func TestWebServerExecuteInvalidHeader(t *testing.T) {
 	globalOption.Self = false
 	globalOption.Archive = flags.Filename(zipname)
 
-	cmd := WebServer{Listen: "127.0.0.1:0", Headers: []string{"invalid-header"}}
+	cmd := WebServer{Listen: []string{"127.0.0.1:0"}, Headers: []string{"invalid-header"}}
 	err := cmd.Execute(nil)
 	if err == nil {
 		t.Error("expected invalid header error")
@@ -794,3 +828,132 @@ func createSimpleZip(path, name string, content []byte) error {
 	}
 	return nil
 }
+
+func TestZipFileFileOpenSection(t *testing.T) {
+	t.Parallel()
+	name := prepare_testzip(t)
+	zf, err := NewZipFileFile(name)
+	if err != nil {
+		t.Error("new zip file", err)
+		return
+	}
+	defer zf.Close()
+	for i := range zf.Files() {
+		fi := zf.File(i)
+		rd, err := zf.OpenSection(i, fi)
+		if err != nil {
+			t.Error("open section", fi.Name, err)
+			continue
+		}
+		if _, ok := rd.(*sectionFile); !ok {
+			t.Errorf("not a section file: %T", rd)
+		}
+		got, err := io.ReadAll(rd)
+		_ = rd.Close()
+		if err != nil {
+			t.Error("read section", fi.Name, err)
+			continue
+		}
+		raw, err := fi.OpenRaw()
+		if err != nil {
+			t.Error("open raw", fi.Name, err)
+			continue
+		}
+		expected, err := io.ReadAll(raw)
+		if err != nil {
+			t.Error("read raw", fi.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, expected) {
+			t.Error("data mismatch", fi.Name, len(got), len(expected))
+		}
+	}
+}
+
+func TestSendfileResponse(t *testing.T) {
+	t.Parallel()
+	zipname := prepare_testzip(t)
+	for _, nosendfile := range []bool{false, true} {
+		h := ZipHandler{
+			indexname:  "index.html",
+			methodmap:  make(map[string]map[uint16]int),
+			nosendfile: nosendfile,
+		}
+		if err := h.initialize_file([]string{zipname}); err != nil {
+			t.Error("initialize_file", err)
+			return
+		}
+		srv := httptest.NewServer(&h)
+		for _, tc := range []struct {
+			path     string
+			encoding string
+		}{
+			{"/512b.txt", ""},
+			{"/4kb.txt", "deflate"},
+		} {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tc.path, nil)
+			if err != nil {
+				t.Error("request", err)
+				continue
+			}
+			req.Header.Set("Accept-Encoding", tc.encoding)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Error("get", tc.path, err)
+				continue
+			}
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if err != nil {
+				t.Error("read body", tc.path, err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Error("status", tc.path, resp.StatusCode)
+			}
+			if int64(len(body)) != resp.ContentLength {
+				t.Error("length", tc.path, nosendfile, len(body), resp.ContentLength)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != tc.encoding {
+				t.Error("encoding", tc.path, enc)
+			}
+		}
+		srv.Close()
+		_ = h.Close()
+	}
+}
+
+func TestOpenSectionReplaced(t *testing.T) {
+	t.Parallel()
+	zipname := prepare_testzip(t)
+	h := ZipHandler{
+		indexname: "index.html",
+		methodmap: make(map[string]map[uint16]int),
+	}
+	if err := h.initialize_file([]string{zipname}); err != nil {
+		t.Error("initialize_file", err)
+		return
+	}
+	defer h.Close()
+	req := httptest.NewRequest(http.MethodGet, "http://dummy.url.com/512b.txt", nil)
+	expected := httptest.NewRecorder()
+	h.ServeHTTP(expected, req)
+	// replace by rename before reload
+	tmpname := zipname + ".new"
+	if err := createSimpleZip(tmpname, "512b.txt", bytes.Repeat([]byte("x"), 512)); err != nil {
+		t.Error("create", err)
+		return
+	}
+	if err := os.Rename(tmpname, zipname); err != nil {
+		t.Error("rename", err)
+		return
+	}
+	zf := h.zipfiles[0].(*ZipFileFile)
+	if _, err := zf.OpenSection(0, zf.File(0)); !errors.Is(err, ErrArchiveReplaced) {
+		t.Error("open section", err)
+	}
+	got := httptest.NewRecorder()
+	h.ServeHTTP(got, req)
+	if got.Code != http.StatusOK || !bytes.Equal(got.Body.Bytes(), expected.Body.Bytes()) {
+		t.Error("body", got.Code, got.Body.Len(), expected.Body.Len())
+	}
+}
@@ -12,36 +12,49 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/schollz/progressbar/v3"
 )
 
 type ZipCmd struct {
-	StripRoot bool     `short:"s" long:"strip-root" description:"strip root path"`
-	Exclude   []string `short:"x" long:"exclude" description:"exclude files"`
-	Stored    []string `short:"n" long:"store" description:"non compress patterns"`
-	MinSize   uint     `short:"m" long:"min-size" description:"compress minimum size" default:"512"`
-	Method    string   `long:"method" choice:"deflate" choice:"zopfli" choice:"brotli" choice:"store" choice:"zstd" default:"zopfli" description:"compression method"`
-	Level     int      `long:"compress-level" default:"-1"`
-	UseAsIs   bool     `long:"asis" description:"copy as-is from zipfile"`
-	BaseURL   string   `long:"baseurl" description:"rewrite html link to relative"`
-	SiteMap   string   `long:"sitemap" description:"generate sitemap.xml"`
-	Parallel  uint     `short:"p" long:"parallel" description:"parallel compression"`
-	Delete    bool     `long:"delete" description:"skip removed files"`
-	NoCRC     bool     `long:"no-crc" description:"do not use CRC32 to detect change"`
-	Last      bool     `long:"choose-last" description:"choose first of same as last"`
-	SortBy    string   `long:"sort-by" choice:"none" choice:"name" choice:"time" choice:"usize" choice:"csize"`
-	Reverse   bool     `short:"r" long:"reverse" description:"reversed order"`
-	InMemory  bool     `long:"in-memory" description:"do not use /tmp"`
-	Progress  bool     `long:"progress" description:"show progress bar"`
-	SkipStore bool     `long:"skip-store" description:"skip file if stored method"`
+	StripRoot   bool     `short:"s" long:"strip-root" description:"strip root path"`
+	Exclude     []string `short:"x" long:"exclude" description:"exclude files"`
+	Stored      []string `short:"n" long:"store" description:"non compress patterns"`
+	MinSize     uint     `short:"m" long:"min-size" description:"compress minimum size" default:"512"`
+	Method      string   `long:"method" choice:"deflate" choice:"zopfli" choice:"brotli" choice:"store" choice:"zstd" default:"zopfli" description:"compression method"`
+	Level       int      `long:"compress-level" default:"-1"`
+	UseAsIs     bool     `long:"asis" description:"copy as-is from zipfile"`
+	BaseURL     string   `long:"baseurl" description:"rewrite html link to relative"`
+	SiteMap     string   `long:"sitemap" description:"generate sitemap.xml"`
+	Parallel    uint     `short:"p" long:"parallel" description:"parallel compression"`
+	Delete      bool     `long:"delete" description:"skip removed files"`
+	NoCRC       bool     `long:"no-crc" description:"do not use CRC32 to detect change"`
+	Last        bool     `long:"choose-last" description:"choose first of same as last"`
+	SortBy      string   `long:"sort-by" choice:"none" choice:"name" choice:"time" choice:"usize" choice:"csize"`
+	Reverse     bool     `short:"r" long:"reverse" description:"reversed order"`
+	InMemory    bool     `long:"in-memory" description:"do not use /tmp"`
+	Progress    bool     `long:"progress" description:"show progress bar"`
+	SkipStore   bool     `long:"skip-store" description:"skip file if stored method"`
+	Sidecar     bool     `long:"sidecar" description:"write index sidecar(<archive>.idx)"`
+	MetaRules   string   `long:"meta-rules" description:"json rules of per-entry HTTP metadata"`
+	HeadersFile bool     `long:"headers-file" description:"read per-entry HTTP metadata from <file>.headers"`
+	Digest      bool     `long:"digest" description:"store SHA-256 of entries in extra field"`
+	Dictionary  []string `long:"dictionary" description:"build dcz entries compressed with dictionary(previous version or trained)"`
+	DictPattern []string `long:"dictionary-pattern" description:"patterns to build dcz entries (default: all)"`
+	Preload     bool     `long:"preload" description:"extract preload links of css/js from html"`
+	ServerConf  string   `long:"server-config" description:"embed config file(YAML or TOML) of webserver, used by --self binary as defaults"`
+	ServerOpts  []string `long:"server-option" description:"embed option of webserver(e.g. listen=:8888), used by --self binary as defaults"`
 
 	method      uint16
 	nametable   map[string][]*ChooseFile
 	zip_to_read []*zip.ReadCloser
 	zipios      []ZipIO
 	args        []string
+	rules       []MetaRule
+	comment     string
+	preloads    map[string]*[]string
 }
 
 func (cmd *ZipCmd) makewriter(zipfile *zip.Writer) {
@@ -131,6 +144,10 @@ func (cmd *ZipCmd) copy_asis(output *zip.Writer, name string, input *ChooseFile)
 	fh := input.Header()
 	fh.Method = input.ZipFile.Method
 	fh.Name = name
+	if err = cmd.apply_meta(&fh, input); err != nil {
+		slog.Error("metadata", "name", name, "error", err)
+		return err
+	}
 	wr, err := output.CreateRaw(&fh)
 	if err != nil {
 		slog.Error("CreateRaw", "name", name, "error", err)
@@ -160,6 +177,10 @@ func (cmd *ZipCmd) copy_compress_job(jobs chan<- CompressWork, name string, inpu
 		return nil
 	}
 	slog.Debug("store", "name", name, "size", input.UncompressedSize, "min", cmd.MinSize)
+	if err = cmd.apply_meta(&fh, input); err != nil {
+		slog.Error("metadata", "name", name, "error", err)
+		return err
+	}
 	ifp, err := input.Open()
 	if err != nil {
 		slog.Error("source Open", "name", name, "error", err)
@@ -179,6 +200,14 @@ func (cmd *ZipCmd) copy_compress_job(jobs chan<- CompressWork, name string, inpu
 		Reader: ifp,
 		MyURL:  myurl,
 	}
+	if cmd.Preload && is_html(name) {
+		// filled by worker while html is parsed
+		cw.Preload = new([]string)
+		if cmd.preloads == nil {
+			cmd.preloads = make(map[string]*[]string)
+		}
+		cmd.preloads[name] = cw.Preload
+	}
 	jobs <- cw
 	return nil
 }
@@ -245,6 +274,25 @@ func (cmd *ZipCmd) validate(args []string) (err error) {
 	default:
 		cmd.method = zip.Deflate
 	}
+	if cmd.MetaRules != "" {
+		if cmd.rules, err = LoadMetaRules(cmd.MetaRules); err != nil {
+			slog.Error("load metadata rules", "name", cmd.MetaRules, "error", err)
+			return err
+		}
+	}
+	if cmd.ServerConf != "" || len(cmd.ServerOpts) != 0 {
+		conf, err := server_config(cmd.ServerConf, cmd.ServerOpts)
+		if err != nil {
+			slog.Error("server config", "name", cmd.ServerConf, "option", cmd.ServerOpts, "error", err)
+			return err
+		}
+		if cmd.comment, err = self_config_comment(conf); err != nil {
+			return err
+		}
+		if !globalOption.Self {
+			slog.Warn("server config is used by --self binary")
+		}
+	}
 	return nil
 }
 
@@ -275,6 +323,10 @@ func (cmd *ZipCmd) create_nametable(args []string) (err error) {
 						slog.Debug("exclude-match", "path", path, "exclude", cmd.Exclude)
 						return nil
 					}
+					if cmd.HeadersFile && strings.HasSuffix(path, HeadersSuffix) {
+						slog.Debug("headers file", "path", path)
+						return nil
+					}
 					relpath, err := filepath.Rel(dirname, path)
 					if err != nil {
 						slog.Error("Relpath", "root", dirname, "path", path, "error", err)
@@ -560,6 +612,14 @@ func (cmd *ZipCmd) Execute(args []string) (err error) {
 		slog.Error("0-th zipio close", "error", err)
 	}
 	slog.Info("wait done")
+	if cmd.Sidecar {
+		// runs after output is closed
+		defer func() {
+			if err == nil {
+				err = WriteZipIndex(string(globalOption.Archive), sidecarFilename(string(globalOption.Archive)), false)
+			}
+		}()
+	}
 	ofp, zipfile, err := cmd.prepare_output()
 	if ofp != nil {
 		defer func() {
@@ -579,6 +639,12 @@ func (cmd *ZipCmd) Execute(args []string) (err error) {
 		slog.Error("open output", "error", err)
 		return err
 	}
+	if cmd.comment != "" {
+		if err = zipfile.SetComment(cmd.comment); err != nil {
+			slog.Error("embed server config", "error", err)
+			return err
+		}
+	}
 	fileinzips := make([]*zip.File, 0)
 	for idx, z := range cmd.zipios {
 		reader, err := z.Reader()
@@ -590,8 +656,27 @@ func (cmd *ZipCmd) Execute(args []string) (err error) {
 	}
 	cmd.sort_files(fileinzips)
 	slog.Info("all files", "num", len(fileinzips))
-	if err = ZipPassThru(zipfile, fileinzips); err != nil {
+	metafns := make([]func(*zip.File, *ZipMeta) error, 0)
+	if cmd.Digest {
+		metafns = append(metafns, set_digest)
+	}
+	if cmd.Preload {
+		metafns = append(metafns, cmd.set_preload)
+	}
+	if len(metafns) != 0 {
+		if err = ZipPassThruMeta(zipfile, fileinzips, metafns...); err != nil {
+			slog.Error("ZipPassThruMeta", "error", err)
+			return err
+		}
+	} else if err = ZipPassThru(zipfile, fileinzips); err != nil {
 		slog.Error("ZipPassthru", "error", err)
+		return err
+	}
+	if len(cmd.Dictionary) != 0 {
+		if err = cmd.write_dictionary_variants(zipfile, fileinzips); err != nil {
+			slog.Error("dictionary variants", "error", err)
+			return err
+		}
 	}
 	return nil
 }
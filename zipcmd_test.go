@@ -761,3 +761,32 @@ func TestZipCmdSelfNoDel(t *testing.T) {
 		zipcmd_helper_check(t, outfile, expected)
 	}
 }
+
+func TestZipCmdSidecar(t *testing.T) {
+	orig_global := globalOption
+	defer func() {
+		globalOption = orig_global
+	}()
+	fname := prepare_testzip(t)
+	output := filepath.Join(t.TempDir(), "output.zip")
+	zz := ZipCmd{
+		Exclude: []string{"128m*"},
+		MinSize: 512,
+		Method:  "deflate",
+		UseAsIs: true,
+		Sidecar: true,
+	}
+	globalOption.Archive = flags.Filename(output)
+	if err := zz.Execute([]string{fname}); err != nil {
+		t.Error("failed", err)
+		return
+	}
+	idx, err := LoadZipIndex(output)
+	if err != nil {
+		t.Error("load sidecar", err)
+		return
+	}
+	if len(idx.Entries) != 3 {
+		t.Error("entries", len(idx.Entries))
+	}
+}
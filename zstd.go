@@ -4,6 +4,7 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"io"
 	"log/slog"
 
@@ -24,4 +25,23 @@ func init() {
 	zip.RegisterDecompressor(Zstd, func(input io.Reader) io.ReadCloser {
 		return zstd.NewReader(input)
 	})
+	dynamic_compressors["zstd"] = func(out io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(out), nil
+	}
+}
+
+// dcz_compress compresses data with raw dictionary
+func dcz_compress(dict []byte, data []byte, level int) ([]byte, error) {
+	if level == -1 {
+		level = zstd.DefaultCompression
+	}
+	buf := bytes.Buffer{}
+	wr := zstd.NewWriterLevelDict(&buf, level, dict)
+	if _, err := wr.Write(data); err != nil {
+		return nil, err
+	}
+	if err := wr.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -3,9 +3,14 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 )
 
 func MakeZstdWriter(zipfile MyZipWriter, level int) {
 	slog.Warn("zstd not supported")
 }
+
+func dcz_compress(dict []byte, data []byte, level int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}